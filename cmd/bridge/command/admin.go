@@ -0,0 +1,133 @@
+package command
+
+import (
+	"breaker/feature"
+	"breaker/pkg/admin"
+	"breaker/pkg/breaker"
+	"breaker/pkg/errwrap"
+	"breaker/pkg/health"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type bridgeStatus struct {
+	State      string                `json:"state"`
+	SessionID  string                `json:"session_id,omitempty"`
+	ServerAddr string                `json:"server_addr,omitempty"`
+	Proxies    []breaker.ProxyStatus `json:"proxies"`
+}
+
+type reloadResult struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Updated []string `json:"updated"`
+}
+
+// newAdminServer serves the status of the bridge:
+//
+//	GET  /api/status    the connection state and the status of every proxy
+//	POST /api/reload    reload the proxies of the config file, unchanged proxies keep running
+//
+// GET /metrics is registered by the bridge for prometheus.
+func newAdminServer(user, password string, cli *breaker.Client, checkers *healthCheckers) *admin.Server {
+	s := admin.NewServer(user, password)
+	s.HandleFunc("/api/status", map[string]http.HandlerFunc{
+		http.MethodGet: func(rw http.ResponseWriter, req *http.Request) {
+			status := bridgeStatus{
+				State:   cli.State().String(),
+				Proxies: cli.ProxyStatuses(),
+			}
+			if cli.State() == breaker.StateConnected {
+				status.SessionID = cli.SessionID()
+				status.ServerAddr = cli.ServerAddr()
+			}
+			admin.WriteJSON(rw, http.StatusOK, status)
+		},
+	})
+	s.HandleFunc("/api/reload", map[string]http.HandlerFunc{
+		http.MethodPost: func(rw http.ResponseWriter, req *http.Request) {
+			result, err := reloadProxies(cli, checkers)
+			if err != nil {
+				log.Errorf("reload %s error: %s", cfgFile, err)
+				admin.WriteError(rw, http.StatusBadRequest, err.Error())
+				return
+			}
+			log.Infof("reload %s, added:%v, removed:%v, updated:%v", cfgFile, result.Added, result.Removed, result.Updated)
+			admin.WriteJSON(rw, http.StatusOK, result)
+		},
+	})
+	return s
+}
+
+// reloadProxies reads the config file again and applies the changes of proxies,
+// other options take effect after restart.
+func reloadProxies(cli *breaker.Client, checkers *healthCheckers) (*reloadResult, error) {
+	conf := &feature.BridgeConfig{}
+	if err := feature.LoadFromFile(cfgFile, conf); err != nil {
+		return nil, err
+	}
+	if err := errwrap.PanicToError(func() {
+		conf.OnInit()
+	}); err != nil {
+		return nil, err
+	}
+	result := &reloadResult{}
+	result.Added, result.Removed, result.Updated = cli.UpdateProxies(conf.Proxies)
+	for _, name := range result.Removed {
+		checkers.stop(name)
+		deleteProxyMetrics(name)
+	}
+	for _, name := range result.Updated {
+		checkers.stop(name)
+		checkers.start(conf.Proxies[name])
+	}
+	for _, name := range result.Added {
+		checkers.start(conf.Proxies[name])
+	}
+	return result, nil
+}
+
+// healthCheckers are the running health checks by proxy name.
+type healthCheckers struct {
+	cli      *breaker.Client
+	checkers map[string]*health.Checker
+	lock     sync.Mutex
+}
+
+func newHealthCheckers(cli *breaker.Client) *healthCheckers {
+	return &healthCheckers{
+		cli:      cli,
+		checkers: make(map[string]*health.Checker),
+	}
+}
+
+// start checks the local service of the proxy if health check is enabled.
+func (h *healthCheckers) start(pc *feature.ProxyConfig) {
+	if pc.HealthCheckType == "" {
+		return
+	}
+	checker := health.NewChecker(pc.HealthCheckType,
+		net.JoinHostPort(pc.LocalIP, strconv.Itoa(pc.LocalPort)), pc.HealthCheckPath,
+		time.Duration(pc.HealthCheckIntervalS)*time.Second,
+		time.Duration(pc.HealthCheckTimeoutS)*time.Second, pc.HealthCheckMaxFailed)
+	checker.OnDown = func() { h.cli.SetProxyHealth(pc, false) }
+	checker.OnUp = func() { h.cli.SetProxyHealth(pc, true) }
+	h.lock.Lock()
+	h.checkers[pc.ProxyName] = checker
+	h.lock.Unlock()
+	checker.Start()
+}
+
+func (h *healthCheckers) stop(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if checker, ok := h.checkers[name]; ok {
+		checker.Stop()
+		delete(h.checkers, name)
+	}
+}
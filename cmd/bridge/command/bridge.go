@@ -5,6 +5,8 @@ import (
 	"breaker/pkg/breaker"
 	"breaker/pkg/netio"
 	"breaker/pkg/protocol"
+	"breaker/pkg/proxy"
+	"breaker/pkg/transport"
 	"breaker/plugin"
 	"fmt"
 	log "github.com/sirupsen/logrus"
@@ -13,7 +15,9 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"syscall"
+	"time"
 )
 
 const (
@@ -38,7 +42,11 @@ var cmdRoot = &cobra.Command{
 			os.Exit(1)
 		}
 		conf.OnInit()
-		cli := NewBridge(conf)
+		cli, err := NewBridge(conf)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		err = cli.Start()
 		if err != nil {
 			fmt.Println(err)
@@ -62,26 +70,94 @@ func init() {
 
 }
 
-func NewBridge(conf *feature.BridgeConfig) *breaker.Client {
-	cli := breaker.NewClient(
+func NewBridge(conf *feature.BridgeConfig) (*breaker.Client, error) {
+	codec, err := breaker.CodecByName(conf.Codec)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := transport.New(conf.Protocol, conf.ToKCPConfig())
+	if err != nil {
+		return nil, err
+	}
+	// lastState is only changed by the goroutine of cli.Start
+	var lastState breaker.ClientState
+	opts := []breaker.ClientOption{
 		breaker.ClientConf(conf),
-	)
+		breaker.ClientCodec(codec),
+		breaker.ClientMux(conf.TcpMux),
+		breaker.ClientTransport(tr),
+		breaker.ClientServers(breaker.NewServerList(conf.ServerAddrs, conf.ServerSRV,
+			conf.ServerSelect == feature.ServerSelectRandom)),
+		breaker.ClientReconnect(time.Duration(conf.ReconnectInitialInterval)*time.Second,
+			time.Duration(conf.ReconnectMaxInterval)*time.Second, conf.ReconnectMaxRetries),
+		breaker.ClientLoginFailExit(*conf.LoginFailExit),
+		breaker.ClientOnStateChange(func(state breaker.ClientState) {
+			log.Infof("bridge is %s", state)
+			if lastState == breaker.StateConnecting && state == breaker.StateDisconnected {
+				loginFailures.With().Inc()
+			}
+			lastState = state
+		}),
+	}
+	if conf.TLSEnable {
+		tlsConfig, err := transport.NewClientTLSConfig(conf.TLSCertFile, conf.TLSKeyFile,
+			conf.TLSTrustedCaFile, conf.TLSServerName)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, breaker.ClientTLSConfig(tlsConfig))
+	}
+	cli := breaker.NewClient(opts...)
+	limiters := newBandwidthLimiters()
 	cli.Use(breaker.RecoverMiddleware())
 
 	cli.AddRoute(&protocol.NewProxyResp{}, func(ctx breaker.Context) {
 		log.Infof("get message NewProxyResp,session id :[%s]", ctx.Session().ID())
-		cmd := ctx.Request().(*protocol.NewProxyResp)
-		ctx.SetRedirectMessage(&protocol.ReqWorkCtl{
-			ProxyName: cmd.ProxyName,
-		})
-		if conf.PluginFileServer.FileLocation != "" && cli.FileServer == nil {
+		cmd := &protocol.NewProxyResp{}
+		if err := ctx.Bind(cmd); err != nil {
+			log.Errorf("bind NewProxyResp error:%s", err)
+			return
+		}
+		pc, ok := cli.ProxyConf(cmd.ProxyName)
+		if !ok {
+			log.Errorf("proxy:[%s] is not configured", cmd.ProxyName)
+			return
+		}
+		cli.SetProxyResult(cmd)
+		if cmd.Error != "" {
+			log.Errorf("proxy:[%s] start error:%s", cmd.ProxyName, cmd.Error)
+			return
+		}
+		if pc.RemotePort == 0 && cmd.RemotePort != 0 {
+			log.Infof("proxy:[%s] is allocated remote port:[%d]", cmd.ProxyName, cmd.RemotePort)
+		}
+		if pc.Plugin == feature.PluginFileServerName && cli.FileServer == nil {
 			fileSrv := plugin.NewFileServer(conf.PluginFileServer.FileLocation, conf.PluginFileServer.Prefix)
 			cli.FileServer = fileSrv
 			go cli.FileServer.Run()
 		}
+		poolCount := 1
+		// the capability of the session the response arrived on, the client may have logged in again
+		if ctx.Session().Capability().HasFeature(protocol.FeatureStartWorkConn) {
+			// pooled work connections don't dial the local service until they're started
+			poolCount = pc.PoolCount
+			if cmd.PoolCount > 0 && cmd.PoolCount < poolCount {
+				// the portal keeps no more than its max_pool_count
+				poolCount = cmd.PoolCount
+			}
+		}
+		for i := 0; i < poolCount; i++ {
+			go handleWorkConn(cli, pc, limiters)
+		}
 	})
 	cli.AddRoute(&protocol.CloseProxyResp{}, func(ctx breaker.Context) {
-		if cli.FileServer != nil {
+		cmd := &protocol.CloseProxyResp{}
+		if err := ctx.Bind(cmd); err != nil {
+			log.Errorf("bind CloseProxyResp error:%s", err)
+			return
+		}
+		pc, ok := cli.ProxyConf(cmd.ProxyName)
+		if ok && pc.Plugin == feature.PluginFileServerName && cli.FileServer != nil {
 			cli.FileServer.Close()
 			cli.FileServer = nil
 		}
@@ -91,39 +167,162 @@ func NewBridge(conf *feature.BridgeConfig) *breaker.Client {
 
 	})
 	cli.AddRoute(&protocol.ReqWorkCtl{}, func(ctx breaker.Context) {
-		if cli.FileServer != nil {
-			workerConn, err := cli.CreateWorkerConn()
-			if err != nil {
-				log.Errorf(err.Error())
-				return
-			}
-			err = cli.FileServer.HandlerConn(workerConn, nil)
-			if err != nil {
-				log.Errorf(err.Error())
-				return
-			}
+		cmd := &protocol.ReqWorkCtl{}
+		if err := ctx.Bind(cmd); err != nil {
+			log.Errorf("bind ReqWorkCtl error:%s", err)
 			return
 		}
-		addr := net.JoinHostPort("0.0.0.0", strconv.Itoa(cli.Conf.LocalPort))
-		log.Tracef("dial local tcp:[%s]", addr)
-		local, err := net.Dial("tcp", addr)
-		if err != nil {
-			log.Errorf(err.Error())
+		pc, ok := cli.ProxyConf(cmd.ProxyName)
+		if !ok {
+			log.Errorf("proxy:[%s] is not configured", cmd.ProxyName)
 			return
 		}
-		workerConn, err := cli.CreateWorkerConn()
+		go handleWorkConn(cli, pc, limiters)
+	})
+
+	cli.AddRoute(&protocol.NewWorkCtlResp{}, func(ctx breaker.Context) {
+
+	})
+	checkers := newHealthCheckers(cli)
+	for _, pc := range conf.Proxies {
+		checkers.start(pc)
+	}
+	if conf.AdminAddr != "" {
+		lis, err := net.Listen("tcp", conf.AdminAddr)
 		if err != nil {
-			local.Close()
+			return nil, err
+		}
+		adminServer := newAdminServer(conf.AdminUser, conf.AdminPwd, cli, checkers)
+		adminServer.Handle("/metrics", newMetricsRegistry(cli))
+		log.Infof("start admin api:%s", conf.AdminAddr)
+		go func() {
+			if err := adminServer.Serve(lis); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
+	for _, vc := range conf.Visitors {
+		vc := vc
+		visitor := proxy.NewVisitor(vc.VisitorName, func() (net.Conn, error) {
+			return cli.CreateVisitorConn(vc)
+		})
+		if err := visitor.Serve(net.JoinHostPort(vc.BindAddr, strconv.Itoa(vc.BindPort))); err != nil {
+			return nil, err
+		}
+	}
+	return cli, nil
+}
+
+// handleWorkConn creates a work connection of the proxy, once it's started by the
+// portal it's served by the plugin or tunneled to the local service.
+func handleWorkConn(cli *breaker.Client, pc *feature.ProxyConfig, limiters *bandwidthLimiters) {
+	workerConn, start, err := cli.CreateWorkerConn(pc)
+	if err != nil {
+		log.Errorf(err.Error())
+		return
+	}
+	in, out := limiters.get(pc)
+	workerConn = netio.NewLimitConn(workerConn, in, out)
+	if pc.Plugin == feature.PluginFileServerName {
+		if cli.FileServer == nil {
+			log.Errorf("proxy:[%s] file server is not running", pc.ProxyName)
+			workerConn.Close()
+			return
+		}
+		if err := cli.FileServer.HandlerConn(workerConn, nil); err != nil {
 			log.Errorf(err.Error())
+			workerConn.Close()
+		}
+		return
+	}
+	addr := net.JoinHostPort(pc.LocalIP, strconv.Itoa(pc.LocalPort))
+	if pc.Type == protocol.ProxyTypeUDP {
+		proxy.RelayUDP(workerConn, addr)
+		return
+	}
+	log.Tracef("dial local tcp:[%s] for proxy:[%s]", addr, pc.ProxyName)
+	local, err := net.Dial("tcp", addr)
+	if err != nil {
+		log.Errorf(err.Error())
+		workerConn.Close()
+		return
+	}
+	if pc.ProxyProtocolVersion != "" {
+		if err := writeProxyProtocolHeader(local, pc, start); err != nil {
+			log.Errorf("proxy:[%s] write proxy protocol header error:%s", pc.ProxyName, err)
+			local.Close()
+			workerConn.Close()
 			return
 		}
-		go netio.StartTunnel(workerConn, local)
-	})
+	}
+	proxyActiveConns.With(pc.ProxyName).Inc()
+	proxyConns.With(pc.ProxyName).Inc()
+	inBytes, outBytes := netio.StartTunnel(workerConn, local)
+	if _, ok := cli.ProxyConf(pc.ProxyName); !ok {
+		// the proxy is removed while tunneling, don't bring its series back
+		return
+	}
+	proxyActiveConns.With(pc.ProxyName).Dec()
+	proxyTraffic.With(pc.ProxyName, "in").Add(float64(inBytes))
+	proxyTraffic.With(pc.ProxyName, "out").Add(float64(outBytes))
+}
 
-	cli.AddRoute(&protocol.NewWorkCtlResp{}, func(ctx breaker.Context) {
+// writeProxyProtocolHeader tells the local service the addresses of the user, a
+// header without addresses is written if the portal doesn't pass them.
+func writeProxyProtocolHeader(local net.Conn, pc *feature.ProxyConfig, start *protocol.StartWorkConn) error {
+	var srcAddr, dstAddr string
+	if start != nil {
+		srcAddr, dstAddr = start.SrcAddr, start.DstAddr
+	}
+	if srcAddr == "" {
+		log.Warnf("proxy:[%s] source address of the user is unknown", pc.ProxyName)
+	}
+	header, err := netio.ProxyProtocolHeader(pc.ProxyProtocolVersion, srcAddr, dstAddr)
+	if err != nil {
+		return err
+	}
+	_, err = local.Write(header)
+	return err
+}
 
-	})
-	return cli
+// bandwidthLimiters are the limiters of proxies enforcing bandwidth_limit on the bridge,
+// all work connections of a proxy share its limiters.
+type bandwidthLimiters struct {
+	limiters map[string]*proxyLimiters
+	lock     sync.Mutex
+}
+
+type proxyLimiters struct {
+	// pc is the config creating the limiters, they're created again if the proxy is reloaded
+	pc  *feature.ProxyConfig
+	in  *netio.Limiter
+	out *netio.Limiter
+}
+
+func newBandwidthLimiters() *bandwidthLimiters {
+	return &bandwidthLimiters{
+		limiters: make(map[string]*proxyLimiters),
+	}
+}
+
+// get returns the limiters of bytes sent by users and sent back to users, they're nil
+// if the proxy is not limited by the bridge.
+func (b *bandwidthLimiters) get(pc *feature.ProxyConfig) (*netio.Limiter, *netio.Limiter) {
+	if pc.BandwidthLimitBytes == 0 || pc.BandwidthLimitMode != protocol.BandwidthLimitModeBridge {
+		return nil, nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	l, ok := b.limiters[pc.ProxyName]
+	if !ok || l.pc != pc {
+		l = &proxyLimiters{
+			pc:  pc,
+			in:  netio.NewLimiter("proxy:"+pc.ProxyName+" in", pc.BandwidthLimitBytes),
+			out: netio.NewLimiter("proxy:"+pc.ProxyName+" out", pc.BandwidthLimitBytes),
+		}
+		b.limiters[pc.ProxyName] = l
+	}
+	return l.in, l.out
 }
 
 func Execute() error {
@@ -0,0 +1,57 @@
+package command
+
+import (
+	"breaker/pkg/breaker"
+	"breaker/pkg/metrics"
+)
+
+var (
+	loginFailures = metrics.NewCounterVec("breaker_bridge_login_failures_total",
+		"Number of failed logins to the portal.")
+	proxyActiveConns = metrics.NewGaugeVec("breaker_bridge_proxy_active_connections",
+		"Number of user connections being tunneled to the local service.", "proxy")
+	proxyConns = metrics.NewCounterVec("breaker_bridge_proxy_connections_total",
+		"Number of user connections tunneled to the local service.", "proxy")
+	proxyTraffic = metrics.NewCounterVec("breaker_bridge_proxy_traffic_bytes_total",
+		"Bytes tunneled, direction in is sent by users and out is sent back to users.", "proxy", "direction")
+)
+
+// deleteProxyMetrics drops the series of the removed proxy.
+func deleteProxyMetrics(name string) {
+	proxyActiveConns.Delete(name)
+	proxyConns.Delete(name)
+	proxyTraffic.Delete(name, "in")
+	proxyTraffic.Delete(name, "out")
+}
+
+// newMetricsRegistry collects the connection state and proxies of the bridge.
+func newMetricsRegistry(cli *breaker.Client) *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.Register(loginFailures, proxyActiveConns, proxyConns, proxyTraffic,
+		metrics.CollectorFunc(func(e *metrics.Encoder) {
+			connected := 0.0
+			if cli.State() == breaker.StateConnected {
+				connected = 1
+			}
+			e.Family("breaker_bridge_connected", "Whether the bridge is logged in to the portal.", metrics.TypeGauge)
+			e.Sample("breaker_bridge_connected", metrics.L(), connected)
+
+			e.Family("breaker_bridge_session_resp_queue_length",
+				"Number of commands waiting to be written to the master session.", metrics.TypeGauge)
+			if session := cli.Session(); session != nil {
+				id, _ := session.ID().(string)
+				e.Sample("breaker_bridge_session_resp_queue_length", metrics.L("session_id", id),
+					float64(session.QueueLen()))
+			}
+
+			e.Family("breaker_bridge_proxy_up", "Whether the proxy is running on the portal.", metrics.TypeGauge)
+			for _, status := range cli.ProxyStatuses() {
+				up := 0.0
+				if status.Phase == breaker.ProxyPhaseRunning {
+					up = 1
+				}
+				e.Sample("breaker_bridge_proxy_up", metrics.L("proxy", status.Name, "type", status.Type), up)
+			}
+		}))
+	return registry
+}
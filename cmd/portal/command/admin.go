@@ -0,0 +1,96 @@
+package command
+
+import (
+	"breaker/pkg/admin"
+	"breaker/pkg/proxy"
+	"breaker/portal"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type masterInfo struct {
+	SessionID  string    `json:"session_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	LastPing   time.Time `json:"last_ping"`
+	Version    int       `json:"version"`
+	Features   []string  `json:"features"`
+}
+
+type proxyInfo struct {
+	SessionID string `json:"session_id"`
+	proxy.Info
+}
+
+// newAdminServer serves the masters and proxies of the portal:
+//
+//	GET    /api/masters                        list the logged in masters
+//	DELETE /api/masters?session_id=            kick the master, its proxies are closed
+//	GET    /api/proxies                        list the running proxies
+//	DELETE /api/proxies?session_id=&name=      close the proxy
+//
+// GET /metrics is registered by the portal for prometheus.
+func newAdminServer(user, password string, masterManager *portal.MasterManager, pm *proxy.ProxyManager) *admin.Server {
+	s := admin.NewServer(user, password)
+	s.HandleFunc("/api/masters", map[string]http.HandlerFunc{
+		http.MethodGet: func(rw http.ResponseWriter, req *http.Request) {
+			masters := make([]masterInfo, 0)
+			masterManager.Range(func(_, value interface{}) bool {
+				master := value.(*portal.Master)
+				masters = append(masters, masterInfo{
+					SessionID:  master.TrackID,
+					RemoteAddr: master.Conn.RemoteAddr().String(),
+					LastPing:   master.LastPingTime,
+					Version:    master.Capability.Version,
+					Features:   master.Capability.Features,
+				})
+				return true
+			})
+			sort.Slice(masters, func(i, j int) bool {
+				return masters[i].SessionID < masters[j].SessionID
+			})
+			admin.WriteJSON(rw, http.StatusOK, masters)
+		},
+		http.MethodDelete: func(rw http.ResponseWriter, req *http.Request) {
+			sessid := req.URL.Query().Get("session_id")
+			master, ok := masterManager.GetMaster(sessid)
+			if !ok {
+				admin.WriteError(rw, http.StatusNotFound, "master not found")
+				return
+			}
+			log.Infof("admin kick master with session id:[%s]", sessid)
+			// the session is closed by the broken conn, then the master and its proxies are deleted
+			master.Close()
+			rw.WriteHeader(http.StatusNoContent)
+		},
+	})
+	s.HandleFunc("/api/proxies", map[string]http.HandlerFunc{
+		http.MethodGet: func(rw http.ResponseWriter, req *http.Request) {
+			proxies := make([]proxyInfo, 0)
+			pm.Range(func(sessid string, pxy proxy.Proxy) bool {
+				proxies = append(proxies, proxyInfo{SessionID: sessid, Info: pxy.Info()})
+				return true
+			})
+			sort.Slice(proxies, func(i, j int) bool {
+				if proxies[i].Name != proxies[j].Name {
+					return proxies[i].Name < proxies[j].Name
+				}
+				return proxies[i].SessionID < proxies[j].SessionID
+			})
+			admin.WriteJSON(rw, http.StatusOK, proxies)
+		},
+		http.MethodDelete: func(rw http.ResponseWriter, req *http.Request) {
+			query := req.URL.Query()
+			sessid, name := query.Get("session_id"), query.Get("name")
+			if err := pm.DeleteProxy(sessid, name); err != nil {
+				admin.WriteError(rw, http.StatusNotFound, err.Error())
+				return
+			}
+			log.Infof("admin close proxy:[%s] with session id:[%s]", name, sessid)
+			rw.WriteHeader(http.StatusNoContent)
+		},
+	})
+	return s
+}
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"breaker/pkg/metrics"
+	"breaker/pkg/proxy"
+	"breaker/portal"
+)
+
+// reasons of login failures
+const (
+	loginFailedAuth         = "auth_failed"
+	loginFailedIncompatible = "incompatible"
+)
+
+var loginFailures = metrics.NewCounterVec("breaker_portal_login_failures_total",
+	"Number of rejected logins of bridges.", "reason")
+
+// newMetricsRegistry collects the masters and proxies of the portal at scrape time.
+func newMetricsRegistry(masterManager *portal.MasterManager, pm *proxy.ProxyManager) *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.Register(loginFailures, metrics.CollectorFunc(func(e *metrics.Encoder) {
+		e.Family("breaker_portal_master_sessions", "Number of logged in bridges.", metrics.TypeGauge)
+		e.Sample("breaker_portal_master_sessions", metrics.L(), float64(masterManager.GetMasterNum()))
+
+		e.Family("breaker_portal_session_resp_queue_length",
+			"Number of commands waiting to be written to the master session.", metrics.TypeGauge)
+		masterManager.Range(func(_, value interface{}) bool {
+			master := value.(*portal.Master)
+			if master.Session != nil {
+				e.Sample("breaker_portal_session_resp_queue_length", metrics.L("session_id", master.TrackID),
+					float64(master.Session.QueueLen()))
+			}
+			return true
+		})
+	}), metrics.CollectorFunc(func(e *metrics.Encoder) {
+		type proxyStats struct {
+			labels metrics.Labels
+			info   proxy.Info
+			stats  *proxy.Stats
+		}
+		var proxies []proxyStats
+		pm.Range(func(sessid string, pxy proxy.Proxy) bool {
+			info := pxy.Info()
+			proxies = append(proxies, proxyStats{
+				labels: metrics.L("proxy", info.Name, "type", info.Type, "session_id", sessid),
+				info:   info,
+				stats:  pxy.Stats(),
+			})
+			return true
+		})
+		e.Family("breaker_portal_proxy_active_connections", "Number of user connections being tunneled.", metrics.TypeGauge)
+		for _, p := range proxies {
+			e.Sample("breaker_portal_proxy_active_connections", p.labels, float64(p.info.ActiveConns))
+		}
+		e.Family("breaker_portal_proxy_connections_total", "Number of user connections tunneled.", metrics.TypeCounter)
+		for _, p := range proxies {
+			e.Sample("breaker_portal_proxy_connections_total", p.labels, float64(p.info.TotalConns))
+		}
+		e.Family("breaker_portal_proxy_rejected_connections_total",
+			"Number of user connections rejected by the limits of connections.", metrics.TypeCounter)
+		for _, p := range proxies {
+			e.Sample("breaker_portal_proxy_rejected_connections_total", p.labels, float64(p.info.RejectedConns))
+		}
+		e.Family("breaker_portal_proxy_denied_connections_total",
+			"Number of user connections denied by the ip lists.", metrics.TypeCounter)
+		for _, p := range proxies {
+			e.Sample("breaker_portal_proxy_denied_connections_total", p.labels, float64(p.info.DeniedConns))
+		}
+		e.Family("breaker_portal_proxy_traffic_bytes_total",
+			"Bytes tunneled, direction in is sent by users and out is sent back to users.", metrics.TypeCounter)
+		for _, p := range proxies {
+			e.Sample("breaker_portal_proxy_traffic_bytes_total", p.labels.With("direction", "in"), float64(p.info.BytesIn))
+			e.Sample("breaker_portal_proxy_traffic_bytes_total", p.labels.With("direction", "out"), float64(p.info.BytesOut))
+		}
+		e.Family("breaker_portal_work_conn_wait_seconds", "Seconds waiting for a work connection.", metrics.TypeHistogram)
+		for _, p := range proxies {
+			e.Histogram("breaker_portal_work_conn_wait_seconds", p.labels, p.stats.WorkConnWait())
+		}
+	}))
+	return registry
+}
@@ -2,10 +2,16 @@ package command
 
 import (
 	"breaker/feature"
+	"breaker/pkg/auth"
 	"breaker/pkg/breaker"
+	"breaker/pkg/ip"
+	"breaker/pkg/netio"
 	"breaker/pkg/protocol"
 	"breaker/pkg/proxy"
+	"breaker/pkg/transport"
+	"breaker/pkg/vhost"
 	"breaker/portal"
+	"errors"
 	"fmt"
 	"net"
 	"os"
@@ -41,12 +47,24 @@ var cmdRoot = &cobra.Command{
 
 		}
 		conf.OnInit()
-		srv := NewPortalService(conf)
+		srv, err := NewPortalService(conf)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		go func() {
 			if err := srv.Serve(conf.ServerAddr); err != nil {
 				log.Error(err)
 			}
 		}()
+		if conf.KcpBindAddr != "" {
+			go func() {
+				kcpTransport := transport.NewKCPTransport(conf.ToKCPConfig())
+				if err := srv.ServeTransport(kcpTransport, conf.KcpBindAddr); err != nil {
+					log.Error(err)
+				}
+			}()
+		}
 		// 后台运行
 		{
 			osSignals := make(chan os.Signal, 1)
@@ -65,13 +83,80 @@ func init() {
 
 }
 
-func NewPortalService(conf *feature.PortalConfig) *breaker.Server {
-	srv := breaker.NewServer()
+func NewPortalService(conf *feature.PortalConfig) (*breaker.Server, error) {
+	tlsConfig, err := transport.NewServerTLSConfig(conf.TLSCertFile, conf.TLSKeyFile, conf.TLSTrustedCaFile)
+	if err != nil {
+		return nil, err
+	}
+	srv := breaker.NewServer(
+		breaker.WithTLSConfig(tlsConfig, conf.TLSOnly),
+		breaker.WithMux(true),
+	)
 	masterManager := portal.NewMasterManager()
-	pm := &proxy.ProxyManager{
-		RunningProxy: make(map[string]*proxy.TcpProxy),
+	pm := proxy.NewProxyManager()
+	policy := newPortPolicy(conf)
+	registries := &proxyRegistries{
+		stcp:   proxy.NewStcpRegistry(),
+		groups: proxy.NewTcpGroupManager(),
+	}
+	if conf.VhostHTTPPort != 0 {
+		addr := net.JoinHostPort(conf.BindAddr, strconv.Itoa(conf.VhostHTTPPort))
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		registries.http = vhost.NewHTTPMuxer()
+		log.Infof("start vhost http:%s", addr)
+		go func() {
+			if err := registries.http.Serve(lis); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
+	if conf.VhostHTTPSPort != 0 {
+		addr := net.JoinHostPort(conf.BindAddr, strconv.Itoa(conf.VhostHTTPSPort))
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		registries.https = vhost.NewHTTPSMuxer()
+		log.Infof("start vhost https:%s", addr)
+		go func() {
+			if err := registries.https.Serve(lis); err != nil {
+				log.Error(err)
+			}
+		}()
 	}
 
+	if conf.AdminAddr != "" {
+		lis, err := net.Listen("tcp", conf.AdminAddr)
+		if err != nil {
+			return nil, err
+		}
+		adminServer := newAdminServer(conf.AdminUser, conf.AdminPwd, masterManager, pm)
+		adminServer.Handle("/metrics", newMetricsRegistry(masterManager, pm))
+		log.Infof("start admin api:%s", conf.AdminAddr)
+		go func() {
+			if err := adminServer.Serve(lis); err != nil {
+				log.Error(err)
+			}
+		}()
+	}
+
+	var portalIn, portalOut *netio.Limiter
+	if conf.BandwidthLimitBytes > 0 {
+		portalIn = netio.NewLimiter("portal in", conf.BandwidthLimitBytes)
+		portalOut = netio.NewLimiter("portal out", conf.BandwidthLimitBytes)
+	}
+
+	// portalConns is the ceiling of user connections shared by tcp proxies, including
+	// the members of groups, other types of proxies don't acquire it
+	var portalConns *proxy.ConnLimiter
+	if conf.MaxConnections > 0 {
+		portalConns = proxy.NewConnLimiter(conf.MaxConnections, 0, 0, nil)
+	}
+
+	verifier := auth.NewVerifier(conf.AuthToken, time.Duration(conf.AuthMaxTimeDiff)*time.Second)
 	go masterManager.CheckConn()
 	srv.OnSessionClose = func(sess breaker.Session) {
 		sessid := sess.ID().(string)
@@ -79,76 +164,207 @@ func NewPortalService(conf *feature.PortalConfig) *breaker.Server {
 		if err == nil {
 			log.Infof("close master with session id:[%s]", sessid)
 		}
-		err = pm.DeleteProxy(sessid)
-		if err == nil {
-			log.Infof("close proxy with session id:[%s]", sessid)
+		for _, name := range pm.DeleteSessionProxies(sessid) {
+			log.Infof("close proxy:[%s] with session id:[%s]", name, sessid)
 		}
 	}
 	srv.Use(breaker.RecoverMiddleware())
 	srv.AddRoute(&protocol.NewMaster{}, func(ctx breaker.Context) {
+		cmd := &protocol.NewMaster{}
+		if err := ctx.Bind(cmd); err != nil {
+			log.Errorf("bind NewMaster error:%s", err)
+			return
+		}
 		conn := ctx.Conn()
 		sessid := ctx.Session().ID().(string)
+		reject := func(code int, reason string, err error) {
+			log.Errorf("reject master:[%s],error:%s", conn.RemoteAddr(), err)
+			loginFailures.With(reason).Inc()
+			ctx.SetResponseMessage(&protocol.NewMasterResp{
+				Resp: protocol.Resp{Error: "login rejected: " + err.Error(), Code: code},
+			}).SendSync()
+			ctx.SetResponseMessage(nil)
+			ctx.Session().Close()
+		}
+		if err := verifier.Verify(cmd.Timestamp, cmd.Nonce, cmd.PrivilegeKey); err != nil {
+			reject(protocol.CodeAuthFailed, loginFailedAuth, err)
+			return
+		}
+		agreed, err := protocol.Negotiate(srv.Capability, cmd.Capability)
+		if err != nil {
+			reject(protocol.CodeIncompatible, loginFailedIncompatible, err)
+			return
+		}
+		codec, err := breaker.CodecByName(agreed.Codecs[0])
+		if err != nil {
+			reject(protocol.CodeIncompatible, loginFailedIncompatible, err)
+			return
+		}
 		master := portal.NewMaster(sessid, conn)
+		master.Capability = agreed
+		master.Session = ctx.Session()
 		masterManager.AddMaster(master)
-		log.Infof("new master with session id :[%s]", sessid)
+		log.Infof("new master with session id :[%s],protocol version:[%d],codec:[%s],features:%v",
+			sessid, agreed.Version, codec.Name(), agreed.Features)
+		// the response is still encoded by the handshake codec, switch codec after it's written
 		ctx.SetResponseMessage(&protocol.NewMasterResp{
-			SessionId: sessid,
-		})
+			SessionId:  sessid,
+			Capability: agreed,
+		}).SendSync()
+		ctx.SetResponseMessage(nil)
+		ctx.Session().SetCodec(codec)
+		ctx.Session().SetCapability(agreed)
 	})
+	// commands below can only be sent by a logged in master
+	loginRequired := func(next breaker.HandlerFunc) breaker.HandlerFunc {
+		return func(ctx breaker.Context) {
+			sessid := ctx.Session().ID().(string)
+			if _, ok := masterManager.GetMaster(sessid); !ok {
+				log.Errorf("session id:[%s] is not logged in, close it", sessid)
+				ctx.Session().Close()
+				return
+			}
+			next(ctx)
+		}
+	}
 	srv.AddRoute(&protocol.Ping{}, func(ctx breaker.Context) {
 		sessid := ctx.Session().ID().(string)
-		master, ok := masterManager.GetMaster(sessid)
-		resp := &protocol.Pong{}
-		if !ok {
-			resp.Error = "invalid ping with session id:" + sessid
-		}
+		master, _ := masterManager.GetMaster(sessid)
 		master.LastPingTime = time.Now()
-		ctx.SetResponseMessage(resp)
-	})
+		ctx.SetResponseMessage(&protocol.Pong{})
+	}, loginRequired)
 	//从客户端中获取Working conn
 	srv.AddRoute(&protocol.ReqWorkCtlResp{}, func(ctx breaker.Context) {
 
 	})
 	srv.AddRoute(&protocol.NewWorkCtl{}, func(ctx breaker.Context) {
-		cmd := ctx.Request().(*protocol.NewWorkCtl)
+		cmd := &protocol.NewWorkCtl{}
+		if err := ctx.Bind(cmd); err != nil {
+			log.Errorf("bind NewWorkCtl error:%s", err)
+			return
+		}
 		clientWorkConn := ctx.Conn()
-		log.Infof("get client working control:[%s],trace id:[%s]", clientWorkConn.RemoteAddr().String(), cmd.TraceID)
+		log.Infof("get client working control:[%s],trace id:[%s],proxy:[%s]",
+			clientWorkConn.RemoteAddr().String(), cmd.TraceID, cmd.ProxyName)
 		resp := &protocol.NewWorkCtlResp{}
-		pxy, ok := pm.GetProxy(cmd.TraceID)
+		if err := verifier.Verify(cmd.Timestamp, cmd.Nonce, cmd.PrivilegeKey); err != nil {
+			log.Errorf("working control:[%s] error:%s", clientWorkConn.RemoteAddr().String(), err)
+			resp.Error = fmt.Sprintf("working control error:%s", err)
+			ctx.SetResponseMessage(resp).SendSync()
+			clientWorkConn.Close()
+			return
+		}
+		pxy, ok := pm.GetProxy(cmd.TraceID, cmd.ProxyName)
 		if !ok {
 			log.Errorf("working control:[%s] error:proxy not found", clientWorkConn.RemoteAddr().String())
 			resp.Error = fmt.Sprintf("working control:[%s] error:proxy not found", clientWorkConn.RemoteAddr().String())
 			ctx.SetResponseMessage(resp).SendSync()
 			return
 		}
-		select {
-		case pxy.WorkingChan <- clientWorkConn:
-			log.Info("new work connection registered")
-
-			ctx.SetResponseMessage(resp).SendSync()
+		// respond before the work connection can be taken, StartWorkConn must follow the response
+		ctx.SetResponseMessage(resp).SendSync()
+		if err := pxy.PutWorkConn(clientWorkConn); err != nil {
+			log.Errorf("proxy:[%s] put work connection error:%s, discarding", cmd.ProxyName, err)
+			clientWorkConn.Close()
 			return
-		default:
-			log.Errorf("work connection pool is full, discarding")
-			resp.Error = fmt.Sprintf("work connection pool is full, discarding")
+		}
+		log.Info("new work connection registered")
+	}, closeSession)
+	srv.AddRoute(&protocol.NewVisitorConn{}, func(ctx breaker.Context) {
+		cmd := &protocol.NewVisitorConn{}
+		if err := ctx.Bind(cmd); err != nil {
+			log.Errorf("bind NewVisitorConn error:%s", err)
+			return
+		}
+		visitorConn := ctx.Conn()
+		log.Infof("get visitor connection:[%s],proxy:[%s]", visitorConn.RemoteAddr().String(), cmd.ProxyName)
+		resp := &protocol.NewVisitorConnResp{ProxyName: cmd.ProxyName}
+		reject := func(err error) {
+			log.Errorf("visitor connection:[%s] error:%s", visitorConn.RemoteAddr().String(), err)
+			resp.Error = fmt.Sprintf("visitor connection error:%s", err)
 			ctx.SetResponseMessage(resp).SendSync()
+			visitorConn.Close()
+		}
+		pxy, ok := registries.stcp.Get(cmd.ProxyName)
+		if !ok {
+			reject(errors.New("proxy not found"))
 			return
 		}
-	}, func(next breaker.HandlerFunc) breaker.HandlerFunc {
-		return func(ctx breaker.Context) {
-			next(ctx)
-			ctx.Session().Close()
+		if err := pxy.VerifyVisitor(cmd.Timestamp, cmd.Nonce, cmd.SignKey); err != nil {
+			reject(err)
+			return
 		}
-	})
+		workConn, err := pxy.GetUserWorkConn(visitorConn)
+		if err != nil {
+			reject(err)
+			return
+		}
+		ctx.SetResponseMessage(resp).SendSync()
+		go netio.StartTunnel(workConn, visitorConn)
+	}, closeSession)
 	srv.AddRoute(&protocol.NewProxy{}, func(ctx breaker.Context) {
-		cmd := ctx.Request().(*protocol.NewProxy)
-		sessid := cmd.TraceId
+		cmd := &protocol.NewProxy{}
+		if err := ctx.Bind(cmd); err != nil {
+			log.Errorf("bind NewProxy error:%s", err)
+			return
+		}
+		sessid := ctx.Session().ID().(string)
 
 		pxyName := cmd.ProxyName
-		hostPort := net.JoinHostPort("0.0.0.0", strconv.Itoa(cmd.RemotePort))
-		pxy := proxy.NewTcpProxy(pxyName, ctx)
-		err := pxy.Serve(hostPort)
+		poolCount := cmd.PoolCount
+		if poolCount > conf.MaxPoolCount {
+			poolCount = conf.MaxPoolCount
+		}
+		base := proxy.NewBaseProxy(pxyName, ctx.Session(), poolCount)
+		base.UseEncryption = cmd.UseEncryption
+		base.UseCompression = cmd.UseCompression
+		base.Token = conf.AuthToken
+		base.Type = cmd.ProxyType
+		if base.Type == "" {
+			base.Type = protocol.ProxyTypeTCP
+		}
+		if cmd.BandwidthLimit > 0 {
+			base.LimitBandwidth(netio.NewLimiter("proxy:"+pxyName+" in", cmd.BandwidthLimit),
+				netio.NewLimiter("proxy:"+pxyName+" out", cmd.BandwidthLimit))
+		}
+		if conf.BandwidthLimitBytes > 0 {
+			base.LimitBandwidth(portalIn, portalOut)
+		}
+		if cmd.MaxConnections > 0 || cmd.MaxConnectionsPerIP > 0 || cmd.MaxConnectionRatePerIP > 0 {
+			base.ConnLimiter = proxy.NewConnLimiter(cmd.MaxConnections, cmd.MaxConnectionsPerIP,
+				cmd.MaxConnectionRatePerIP, portalConns)
+		} else if portalConns != nil {
+			base.ConnLimiter = portalConns
+		}
+		if conf.ACL != nil {
+			base.ACLs = append(base.ACLs, conf.ACL)
+		}
+		if len(cmd.AllowIPs) > 0 || len(cmd.DenyIPs) > 0 {
+			acl, err := ip.NewACL(cmd.AllowIPs, cmd.DenyIPs)
+			if err != nil {
+				log.Errorf("new Proxy error: %s", err)
+				ctx.SetResponseMessage(&protocol.NewProxyResp{
+					ProxyName: pxyName,
+					Resp:      protocol.Resp{Error: "new Proxy error:" + err.Error()},
+				})
+				return
+			}
+			base.ACLs = append(base.ACLs, acl)
+		}
+		if master, ok := masterManager.GetMaster(sessid); ok {
+			base.StartWorkConn = master.Capability.HasFeature(protocol.FeatureStartWorkConn)
+		}
 		resp := &protocol.NewProxyResp{
 			ProxyName: pxyName,
+			PoolCount: poolCount,
+		}
+		ports, err := policy.remotePorts(cmd)
+		var pxy proxy.Proxy
+		if err == nil {
+			pxy, err = newProxy(conf, registries, cmd, base)
+		}
+		if err == nil {
+			resp.RemotePort, err = serveProxy(pxy, conf.BindAddr, ports)
 		}
 		if err != nil {
 			log.Error("new Proxy error:", err)
@@ -156,30 +372,134 @@ func NewPortalService(conf *feature.PortalConfig) *breaker.Server {
 			ctx.SetResponseMessage(resp)
 			return
 		}
-		log.Infof("newProxy with address:[%s],session id:[%s]", hostPort, sessid)
+		base.RemotePort = resp.RemotePort
+		log.Infof("newProxy:[%s] with address:[%s],session id:[%s]", pxyName,
+			net.JoinHostPort(conf.BindAddr, strconv.Itoa(resp.RemotePort)), sessid)
 		err = pm.AddProxy(sessid, pxy)
 		if err != nil {
+			pxy.Close()
 			resp.Error = "add Proxy error:%+v" + err.Error()
 			ctx.SetResponseMessage(resp)
 			return
 		}
 		ctx.SetResponseMessage(resp)
-	})
+	}, loginRequired)
 	srv.AddRoute(&protocol.CloseProxy{}, func(ctx breaker.Context) {
-		cmd := ctx.Request().(*protocol.CloseProxy)
+		cmd := &protocol.CloseProxy{}
+		if err := ctx.Bind(cmd); err != nil {
+			log.Errorf("bind CloseProxy error:%s", err)
+			return
+		}
 		sessid := ctx.Session().ID().(string)
 		log.Infof("close pxy:%s  ", cmd.ProxyName)
-		err := pm.DeleteProxy(sessid)
-		resp := &protocol.CloseProxyResp{}
+		err := pm.DeleteProxy(sessid, cmd.ProxyName)
+		resp := &protocol.CloseProxyResp{ProxyName: cmd.ProxyName}
 		if err != nil {
 			resp.Error = "close Proxy error:%+v" + err.Error()
 			ctx.SetResponseMessage(resp)
 			return
 		}
 		ctx.SetResponseMessage(resp)
-	})
-	return srv
+	}, loginRequired)
+	return srv, nil
+
+}
+
+// serveProxy serves the proxy on the first free port of ports and returns it,
+// ports are tried in order only if they're allocated for remote_port 0.
+func serveProxy(pxy proxy.Proxy, bindAddr string, ports []int) (int, error) {
+	if len(ports) == 0 {
+		return 0, pxy.Serve(net.JoinHostPort(bindAddr, "0"))
+	}
+	if len(ports) == 1 {
+		return ports[0], pxy.Serve(net.JoinHostPort(bindAddr, strconv.Itoa(ports[0])))
+	}
+	var err error
+	for _, port := range ports {
+		if err = pxy.Serve(net.JoinHostPort(bindAddr, strconv.Itoa(port))); err == nil {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port to allocate, last error:%s", err)
+}
+
+// closeSession closes the session after the handler, the conn is taken over by the handler.
+func closeSession(next breaker.HandlerFunc) breaker.HandlerFunc {
+	return func(ctx breaker.Context) {
+		next(ctx)
+		ctx.Session().Close()
+	}
+}
+
+// proxyRegistries are shared by the proxies of all bridges, the vhost muxers
+// are nil if they're disabled.
+type proxyRegistries struct {
+	http   *vhost.HTTPMuxer
+	https  *vhost.HTTPSMuxer
+	stcp   *proxy.StcpRegistry
+	groups *proxy.TcpGroupManager
+}
 
+// newProxy creates the proxy of cmd.ProxyType, it's not serving yet.
+func newProxy(conf *feature.PortalConfig, registries *proxyRegistries, cmd *protocol.NewProxy,
+	base *proxy.BaseProxy) (proxy.Proxy, error) {
+	switch cmd.ProxyType {
+	case "", protocol.ProxyTypeTCP:
+		if cmd.Group != "" {
+			return proxy.NewGroupTcpProxy(base, registries.groups, proxy.GroupConfig{
+				Name:     cmd.Group,
+				Key:      cmd.GroupKey,
+				Strategy: cmd.GroupStrategy,
+			}), nil
+		}
+		return proxy.NewTcpProxy(base), nil
+	case protocol.ProxyTypeUDP:
+		return proxy.NewUdpProxy(base), nil
+	case protocol.ProxyTypeHTTP:
+		if registries.http == nil {
+			return nil, errors.New("vhost_http_port is not enabled by the portal")
+		}
+		domains, err := proxyDomains(conf, cmd)
+		if err != nil {
+			return nil, err
+		}
+		return proxy.NewHttpProxy(base, registries.http, domains, cmd.Locations), nil
+	case protocol.ProxyTypeHTTPS:
+		if registries.https == nil {
+			return nil, errors.New("vhost_https_port is not enabled by the portal")
+		}
+		domains, err := proxyDomains(conf, cmd)
+		if err != nil {
+			return nil, err
+		}
+		return proxy.NewHttpsProxy(base, registries.https, domains), nil
+	case protocol.ProxyTypeSTCP:
+		if cmd.Sk == "" {
+			return nil, errors.New("sk of stcp proxy is empty")
+		}
+		// the payload is wrapped end to end by the bridges, it's forwarded as it is
+		base.UseEncryption = false
+		base.UseCompression = false
+		window := time.Duration(conf.AuthMaxTimeDiff) * time.Second
+		return proxy.NewStcpProxy(base, registries.stcp, cmd.Sk, window), nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy type:%s", cmd.ProxyType)
+	}
+}
+
+// proxyDomains returns the custom domains and the subdomain joined with subdomain_host.
+func proxyDomains(conf *feature.PortalConfig, cmd *protocol.NewProxy) ([]string, error) {
+	domains := append([]string(nil), cmd.CustomDomains...)
+	if cmd.SubDomain != "" {
+		if conf.SubdomainHost == "" {
+			return nil, errors.New("subdomain_host is not configured by the portal")
+		}
+		domains = append(domains, cmd.SubDomain+"."+conf.SubdomainHost)
+	}
+	if len(domains) == 0 {
+		return nil, errors.New("no domain is configured")
+	}
+	return domains, nil
 }
 
 func Execute() error {
@@ -0,0 +1,130 @@
+package command
+
+import (
+	"breaker/feature"
+	"breaker/pkg/protocol"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// privilegedPorts are below it, they must be listed in allow_ports
+	privilegedPorts = 1024
+	// allocateAttempts is the number of free ports tried for remote_port 0
+	allocateAttempts = 16
+)
+
+// portPolicy decides which remote ports proxies can listen on.
+type portPolicy struct {
+	allowed  []feature.PortRange
+	reserved map[int]bool
+	rand     *rand.Rand
+	randLock sync.Mutex
+}
+
+// newPortPolicy reserves the ports the portal listens on by itself.
+func newPortPolicy(conf *feature.PortalConfig) *portPolicy {
+	p := &portPolicy{
+		allowed:  conf.AllowPortRanges,
+		reserved: make(map[int]bool),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, addr := range []string{conf.ServerAddr, conf.KcpBindAddr, conf.AdminAddr} {
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			if n, err := strconv.Atoi(port); err == nil && n != 0 {
+				p.reserved[n] = true
+			}
+		}
+	}
+	for _, port := range []int{conf.VhostHTTPPort, conf.VhostHTTPSPort} {
+		if port != 0 {
+			p.reserved[port] = true
+		}
+	}
+	return p
+}
+
+// check returns the reason why proxies can't listen on the port.
+func (p *portPolicy) check(port int) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid remote port %d", port)
+	}
+	if p.reserved[port] {
+		return fmt.Errorf("remote port %d is reserved by the portal", port)
+	}
+	if len(p.allowed) > 0 {
+		for _, r := range p.allowed {
+			if r.Contains(port) {
+				return nil
+			}
+		}
+		return fmt.Errorf("remote port %d is not in allow_ports", port)
+	}
+	if port < privilegedPorts {
+		return fmt.Errorf("remote port %d is privileged, it must be listed in allow_ports", port)
+	}
+	return nil
+}
+
+// candidates returns up to n random ports passing check.
+func (p *portPolicy) candidates(n int) []int {
+	ranges := p.allowed
+	if len(ranges) == 0 {
+		ranges = []feature.PortRange{{Start: privilegedPorts, End: 65535}}
+	}
+	total := 0
+	for _, r := range ranges {
+		total += r.End - r.Start + 1
+	}
+	var ports []int
+	seen := make(map[int]bool)
+	p.randLock.Lock()
+	defer p.randLock.Unlock()
+	// bounded since the ranges may hold less than n ports
+	for i := 0; i < n*4 && len(ports) < n; i++ {
+		k := p.rand.Intn(total)
+		port := 0
+		for _, r := range ranges {
+			if size := r.End - r.Start + 1; k >= size {
+				k -= size
+				continue
+			}
+			port = r.Start + k
+			break
+		}
+		if seen[port] || p.check(port) != nil {
+			continue
+		}
+		seen[port] = true
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+// remotePorts returns the ports to try for the proxy in order, it's nil if the
+// proxy doesn't listen on a remote port.
+func (p *portPolicy) remotePorts(cmd *protocol.NewProxy) ([]int, error) {
+	switch cmd.ProxyType {
+	case "", protocol.ProxyTypeTCP, protocol.ProxyTypeUDP:
+	default:
+		return nil, nil
+	}
+	if cmd.RemotePort != 0 {
+		if err := p.check(cmd.RemotePort); err != nil {
+			return nil, err
+		}
+		return []int{cmd.RemotePort}, nil
+	}
+	if cmd.Group != "" {
+		return nil, fmt.Errorf("remote port of group %s can't be allocated", cmd.Group)
+	}
+	ports := p.candidates(allocateAttempts)
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no port to allocate in allow_ports")
+	}
+	return ports, nil
+}
@@ -0,0 +1,154 @@
+package command
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"breaker/feature"
+	"breaker/pkg/protocol"
+	"breaker/pkg/proxy"
+)
+
+func testPolicy(allowed []feature.PortRange) *portPolicy {
+	conf := &feature.PortalConfig{}
+	conf.ServerAddr = "0.0.0.0:7000"
+	conf.KcpBindAddr = "0.0.0.0:7001"
+	conf.AdminAddr = "127.0.0.1:7500"
+	conf.VhostHTTPPort = 8080
+	conf.VhostHTTPSPort = 8443
+	conf.AllowPortRanges = allowed
+	return newPortPolicy(conf)
+}
+
+func TestPortPolicyCheck(t *testing.T) {
+	open := testPolicy(nil)
+	listed := testPolicy([]feature.PortRange{{Start: 80, End: 80}, {Start: 7000, End: 7100}})
+	tests := []struct {
+		name   string
+		policy *portPolicy
+		port   int
+		want   string
+	}{
+		{"free port", open, 35000, ""},
+		{"zero", open, 0, "invalid"},
+		{"too large", open, 65536, "invalid"},
+		{"server port", open, 7000, "reserved"},
+		{"kcp port", open, 7001, "reserved"},
+		{"admin port", open, 7500, "reserved"},
+		{"vhost http port", open, 8080, "reserved"},
+		{"vhost https port", open, 8443, "reserved"},
+		{"privileged port", open, 80, "privileged"},
+		{"lowest unprivileged port", open, 1024, ""},
+		{"privileged port listed", listed, 80, ""},
+		{"in allowed range", listed, 7050, ""},
+		{"reserved in allowed range", listed, 7000, "reserved"},
+		{"outside allowed ranges", listed, 35000, "not in allow_ports"},
+	}
+	for _, tt := range tests {
+		err := tt.policy.check(tt.port)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: check(%d) = %s", tt.name, tt.port, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: check(%d) = %v, want error containing %q", tt.name, tt.port, err, tt.want)
+		}
+	}
+}
+
+func TestPortPolicyCandidates(t *testing.T) {
+	p := testPolicy([]feature.PortRange{{Start: 6999, End: 7002}, {Start: 900, End: 900}})
+	ports := p.candidates(allocateAttempts)
+	// 7000 and 7001 are reserved, so only 3 ports are left
+	if len(ports) > 3 {
+		t.Fatalf("got %d candidates from 3 free ports: %v", len(ports), ports)
+	}
+	seen := make(map[int]bool)
+	for _, port := range ports {
+		if seen[port] || p.check(port) != nil {
+			t.Fatalf("invalid or duplicate candidate %d in %v", port, ports)
+		}
+		seen[port] = true
+	}
+
+	open := testPolicy(nil)
+	ports = open.candidates(allocateAttempts)
+	if len(ports) != allocateAttempts {
+		t.Fatalf("got %d candidates, want %d", len(ports), allocateAttempts)
+	}
+	for _, port := range ports {
+		if port < privilegedPorts {
+			t.Fatalf("privileged candidate %d", port)
+		}
+	}
+}
+
+func TestPortPolicyRemotePorts(t *testing.T) {
+	p := testPolicy(nil)
+	ports, err := p.remotePorts(&protocol.NewProxy{RemotePort: 35000})
+	if err != nil || len(ports) != 1 || ports[0] != 35000 {
+		t.Fatalf("fixed port: %v %v", ports, err)
+	}
+	if _, err := p.remotePorts(&protocol.NewProxy{RemotePort: 7000}); err == nil {
+		t.Fatal("reserved port is accepted")
+	}
+	if ports, err := p.remotePorts(&protocol.NewProxy{ProxyType: protocol.ProxyTypeHTTP}); err != nil || ports != nil {
+		t.Fatalf("http proxy: %v %v", ports, err)
+	}
+	if _, err := p.remotePorts(&protocol.NewProxy{Group: "web"}); err == nil {
+		t.Fatal("port of a group is allocated")
+	}
+	if ports, err := p.remotePorts(&protocol.NewProxy{ProxyType: protocol.ProxyTypeUDP}); err != nil || len(ports) != allocateAttempts {
+		t.Fatalf("allocated ports: %v %v", ports, err)
+	}
+	full := testPolicy([]feature.PortRange{{Start: 7000, End: 7001}})
+	if _, err := full.remotePorts(&protocol.NewProxy{}); err == nil {
+		t.Fatal("allocated from reserved ports only")
+	}
+}
+
+// busyProxy fails to listen on the busy ports.
+type busyProxy struct {
+	proxy.Proxy
+	busy  map[int]bool
+	tried []int
+}
+
+func (p *busyProxy) Serve(addr string) error {
+	_, port, _ := net.SplitHostPort(addr)
+	n, _ := strconv.Atoi(port)
+	p.tried = append(p.tried, n)
+	if p.busy[n] {
+		return errors.New("address already in use")
+	}
+	return nil
+}
+
+func TestServeProxy(t *testing.T) {
+	pxy := &busyProxy{busy: map[int]bool{35000: true, 35001: true}}
+	port, err := serveProxy(pxy, "0.0.0.0", []int{35000, 35001, 35002, 35003})
+	if err != nil || port != 35002 {
+		t.Fatalf("got port %d, %v, want 35002", port, err)
+	}
+	if len(pxy.tried) != 3 {
+		t.Fatalf("tried %v", pxy.tried)
+	}
+
+	ports := testPolicy(nil).candidates(allocateAttempts)
+	busy := make(map[int]bool)
+	for _, port := range ports {
+		busy[port] = true
+	}
+	pxy = &busyProxy{busy: busy}
+	if _, err := serveProxy(pxy, "0.0.0.0", ports); err == nil {
+		t.Fatal("no error when every candidate is busy")
+	}
+	if len(pxy.tried) != allocateAttempts {
+		t.Fatalf("tried %d ports, want %d", len(pxy.tried), allocateAttempts)
+	}
+}
@@ -0,0 +1,16 @@
+package feature
+
+// AdminConfig serves the admin api, requests are protected by basic auth.
+type AdminConfig struct {
+	// AdminAddr is the address of the admin api, it's disabled if it's empty.
+	AdminAddr string `ini:"admin_addr"`
+	// AdminUser and AdminPwd are the basic auth credentials, they're required by AdminAddr.
+	AdminUser string `ini:"admin_user"`
+	AdminPwd  string `ini:"admin_pwd"`
+}
+
+func (c *AdminConfig) OnInit() {
+	if c.AdminAddr != "" && (c.AdminUser == "" || c.AdminPwd == "") {
+		panic("admin_user and admin_pwd are required by admin_addr")
+	}
+}
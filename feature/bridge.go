@@ -1,28 +1,121 @@
 package feature
 
-import "strconv"
+import (
+	"breaker/pkg/protocol"
+	"breaker/pkg/transport"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// values of ServerSelect
+const (
+	ServerSelectOrder  = "order"
+	ServerSelectRandom = "random"
+)
 
 type BridgeConfig struct {
-	LoggerConfig      `ini:"Logger"`
-	PluginFileServer  `ini:"plugin_file_server"`
-	ServerAddr        string `ini:"server_addr"`
+	LoggerConfig     `ini:"Logger"`
+	PluginFileServer `ini:"plugin_file_server"`
+	// ServerAddr is the address of the portal, several portals can be separated by
+	// commas so that the bridge fails over to the next one.
+	ServerAddr string `ini:"server_addr"`
+	// ServerAddrs are the addresses split from ServerAddr.
+	ServerAddrs []string `ini:"-"`
+	// ServerSRV is the full name of a DNS SRV record listing the portals,
+	// e.g. _breaker._tcp.example.com. Its targets are tried before ServerAddrs.
+	ServerSRV string `ini:"server_srv"`
+	// ServerSelect is the order to try the portals, valid values are "order" and
+	// "random". By default, this value is "order".
+	ServerSelect string `ini:"server_select"`
+	// LocalPort, RemotePort, ProxyName, UseEncryption and UseCompression describe
+	// the proxy written at the top level, they are kept for configs written before
+	// [proxy.<name>] sections were supported.
 	LocalPort         int    `ini:"local_port"`
 	RemotePort        int    `ini:"remote_port"`
 	ProxyName         string `ini:"proxy_name"`
 	HeartbeatInterval int64  `ini:"heartbeat_interval" `
+	// ReconnectInitialInterval and ReconnectMaxInterval are the seconds of the backoff
+	// between reconnecting attempts, the delay doubles from the initial one with jitter.
+	// By default, they're 1 and 60.
+	ReconnectInitialInterval int64 `ini:"reconnect_initial_interval"`
+	ReconnectMaxInterval     int64 `ini:"reconnect_max_interval"`
+	// ReconnectMaxRetries is the number of reconnecting attempts before the bridge
+	// exits, 0 means retrying forever.
+	ReconnectMaxRetries int `ini:"reconnect_max_retries"`
+	// LoginFailExit exits if the first login fails, otherwise the bridge keeps
+	// reconnecting. By default, this value is true, so it's a pointer to tell unset from false.
+	LoginFailExit *bool `ini:"login_fail_exit"`
+	// Codec specifies the preferred encoding of commands after login, valid values
+	// are "json", "msgpack" and "binary". The portal may downgrade it to another
+	// codec both sides support. By default, this value is "json".
+	Codec string `ini:"codec"`
+	// AuthToken is shared with the portal to sign the login request.
+	AuthToken string `ini:"auth_token"`
+	// TcpMux multiplexes the master connection and all work connections over
+	// one tcp connection, it's turned off only if the portal replies that mux is
+	// unsupported. By default, this value is false.
+	TcpMux         bool `ini:"tcp_mux"`
+	UseEncryption  bool `ini:"use_encryption"`
+	UseCompression bool `ini:"use_compression"`
+	// Protocol is the transport to connect the portal, valid values are "tcp" and "kcp".
+	// kcp is a reliable transport over udp for lossy links, the portal must set
+	// kcp_bind_addr. By default, this value is "tcp".
+	Protocol        string `ini:"protocol"`
+	AdminConfig     `ini:"DEFAULT,omitempty"`
+	KCPConfig       `ini:"DEFAULT,omitempty"`
+	BridgeTLSConfig `ini:"DEFAULT,omitempty"`
+	// Proxies are the services exposed by the bridge, keyed by proxy name.
+	Proxies map[string]*ProxyConfig `ini:"-"`
+	// Visitors connect to the stcp proxies of other bridges, keyed by visitor name.
+	Visitors map[string]*VisitorConfig `ini:"-"`
+}
+
+// BridgeTLSConfig protects the master connection and work connections with tls.
+type BridgeTLSConfig struct {
+	TLSEnable bool `ini:"tls_enable"`
+	// TLSCertFile and TLSKeyFile are the client certificate, required by portal with tls_trusted_ca_file set.
+	TLSCertFile string `ini:"tls_cert_file"`
+	TLSKeyFile  string `ini:"tls_key_file"`
+	// TLSTrustedCaFile verifies the portal certificate, the portal is not verified if it's empty.
+	TLSTrustedCaFile string `ini:"tls_trusted_ca_file"`
+	// TLSServerName is used to verify the portal certificate, by default it's the host of the portal in use.
+	TLSServerName string `ini:"tls_server_name"`
+}
+
+func (b *BridgeConfig) LoadSections(f *ini.File) error {
+	proxies, err := loadProxySections(f)
+	if err != nil {
+		return err
+	}
+	b.Proxies = proxies
+	visitors, err := loadVisitorSections(f)
+	if err != nil {
+		return err
+	}
+	b.Visitors = visitors
+	return nil
 }
 
 func (b *BridgeConfig) OnInit() {
 	b.LoggerConfig.OnInit()
 	b.PluginFileServer.OnInit()
-	if b.ServerAddr == "" {
+	b.ServerAddrs = nil
+	for _, addr := range strings.Split(b.ServerAddr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			b.ServerAddrs = append(b.ServerAddrs, addr)
+		}
+	}
+	if len(b.ServerAddrs) == 0 && b.ServerSRV == "" {
 		panic("breaker address can not be empty")
 	}
-	if b.LocalPort < 0 || b.LocalPort > 65535 {
-		panic("invalid local port[0-65535]")
+	if b.ServerSelect == "" {
+		b.ServerSelect = ServerSelectOrder
 	}
-	if b.RemotePort < 0 || b.RemotePort > 65535 {
-		panic("invalid remote port[0-65535]")
+	if b.ServerSelect != ServerSelectOrder && b.ServerSelect != ServerSelectRandom {
+		panic("invalid server_select:" + b.ServerSelect)
 	}
 	if b.HeartbeatInterval < 0 {
 		panic("invalid HeartbeatInterval, can't less than 0")
@@ -30,7 +123,80 @@ func (b *BridgeConfig) OnInit() {
 	if b.HeartbeatInterval == 0 {
 		b.HeartbeatInterval = 5
 	}
-	if b.ProxyName == "" {
-		b.ProxyName = b.ServerAddr + "_to_" + strconv.Itoa(b.LocalPort)
+	if b.ReconnectInitialInterval < 0 || b.ReconnectMaxInterval < 0 || b.ReconnectMaxRetries < 0 {
+		panic("invalid reconnect options, can't less than 0")
+	}
+	if b.ReconnectInitialInterval == 0 {
+		b.ReconnectInitialInterval = 1
+	}
+	if b.ReconnectMaxInterval == 0 {
+		b.ReconnectMaxInterval = 60
+	}
+	if b.ReconnectMaxInterval < b.ReconnectInitialInterval {
+		panic("reconnect_max_interval can't less than reconnect_initial_interval")
+	}
+	if b.LoginFailExit == nil {
+		loginFailExit := true
+		b.LoginFailExit = &loginFailExit
+	}
+	if (b.TLSCertFile == "") != (b.TLSKeyFile == "") {
+		panic("tls_cert_file and tls_key_file must be set together")
+	}
+	if b.Codec == "" {
+		b.Codec = protocol.CodecJson
+	}
+	if !protocol.IsSupportedCodec(b.Codec) {
+		panic("invalid codec:" + b.Codec)
+	}
+	if b.Protocol == "" {
+		b.Protocol = transport.ProtocolTCP
+	}
+	if b.Protocol != transport.ProtocolTCP && b.Protocol != transport.ProtocolKCP {
+		panic("invalid protocol:" + b.Protocol)
+	}
+	b.AdminConfig.OnInit()
+	b.KCPConfig.OnInit()
+	if b.Proxies == nil {
+		b.Proxies = make(map[string]*ProxyConfig)
+	}
+	if b.LocalPort != 0 || b.FileLocation != "" {
+		if b.ProxyName == "" {
+			// named after the first portal, server_addr may list several of them
+			server := b.ServerSRV
+			if len(b.ServerAddrs) > 0 {
+				server = b.ServerAddrs[0]
+			}
+			b.ProxyName = server + "_to_" + strconv.Itoa(b.LocalPort)
+		}
+		if _, ok := b.Proxies[b.ProxyName]; ok {
+			panic("duplicate proxy name:" + b.ProxyName)
+		}
+		pc := &ProxyConfig{
+			ProxyName:      b.ProxyName,
+			LocalPort:      b.LocalPort,
+			RemotePort:     b.RemotePort,
+			UseEncryption:  b.UseEncryption,
+			UseCompression: b.UseCompression,
+		}
+		if b.FileLocation != "" {
+			pc.Plugin = PluginFileServerName
+		}
+		b.Proxies[b.ProxyName] = pc
+	}
+	if b.Visitors == nil {
+		b.Visitors = make(map[string]*VisitorConfig)
+	}
+	if len(b.Proxies) == 0 && len(b.Visitors) == 0 {
+		panic("no proxy or visitor configured")
+	}
+	for _, pc := range b.Proxies {
+		pc.OnInit()
+		// stcp proxies derive the key from sk, which is required by their OnInit
+		if pc.UseEncryption && pc.Type != protocol.ProxyTypeSTCP && b.AuthToken == "" {
+			panic(fmt.Sprintf("proxy %s: use_encryption requires auth_token", pc.ProxyName))
+		}
+	}
+	for _, vc := range b.Visitors {
+		vc.OnInit()
 	}
 }
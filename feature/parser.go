@@ -2,9 +2,12 @@ package feature
 
 import (
 	"errors"
+	"fmt"
 	"github.com/go-ini/ini"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -24,10 +27,22 @@ func init() {
 		if err != nil {
 			return err
 		}
-		return f.MapTo(conf)
+		if err := f.MapTo(conf); err != nil {
+			return err
+		}
+		if sl, ok := conf.(SectionLoader); ok {
+			return sl.LoadSections(f)
+		}
+		return nil
 	})
 }
 
+// SectionLoader is implemented by configs with sections that can't be mapped by name,
+// e.g. the repeated proxy sections of bridge.
+type SectionLoader interface {
+	LoadSections(f *ini.File) error
+}
+
 func RegisterLoader(ext string, c Loader) {
 	Loaders[ext] = c
 }
@@ -47,3 +62,70 @@ func LoadFromFile(cfgFile string, conf interface{}) error {
 
 	return loader(b, conf)
 }
+
+// ParseBandwidth parses bytes per second like "1MB", "512KB" or "100B" in units of 1024.
+func ParseBandwidth(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	for _, unit := range units {
+		if !strings.HasSuffix(s, unit.suffix) {
+			continue
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, unit.suffix)), 64)
+		if err != nil || math.IsNaN(n) || n <= 0 {
+			return 0, fmt.Errorf("invalid bandwidth:%s", s)
+		}
+		// values less than 1B would mean unlimited, and huge ones overflow
+		bytes := n * float64(unit.size)
+		if bytes < 1 || bytes >= math.MaxInt64 {
+			return 0, fmt.Errorf("invalid bandwidth:%s, out of range", s)
+		}
+		return int64(bytes), nil
+	}
+	return 0, fmt.Errorf("invalid bandwidth:%s, the unit must be B, KB, MB or GB", s)
+}
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	Start int
+	End   int
+}
+
+func (r PortRange) Contains(port int) bool {
+	return port >= r.Start && port <= r.End
+}
+
+// ParsePortRanges parses ranges like "2000-3000,3001,4000-50000".
+func ParsePortRanges(s string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid port range:%s", part)
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+				return nil, fmt.Errorf("invalid port range:%s", part)
+			}
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range:%s", part)
+		}
+		ranges = append(ranges, PortRange{Start: start, End: end})
+	}
+	return ranges, nil
+}
@@ -0,0 +1,70 @@
+package feature
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"100B", 100, false},
+		{"512kb", 512 << 10, false},
+		{" 1.5 MB ", 3 << 19, false},
+		{"2GB", 2 << 30, false},
+		{"1.9B", 1, false},
+		{"0.5B", 0, true},
+		{"0.0001KB", 0, true},
+		{"0MB", 0, true},
+		{"-1KB", 0, true},
+		{"NaNKB", 0, true},
+		{"InfGB", 0, true},
+		{"1e30GB", 0, true},
+		{"10", 0, true},
+		{"MB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBandwidth(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBandwidth(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParsePortRanges(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []PortRange
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"2000-3000", []PortRange{{2000, 3000}}, false},
+		{"2000-3000,3001, 4000 - 50000", []PortRange{{2000, 3000}, {3001, 3001}, {4000, 50000}}, false},
+		{"80,,443", []PortRange{{80, 80}, {443, 443}}, false},
+		{"1-65535", []PortRange{{1, 65535}}, false},
+		{"0", nil, true},
+		{"65536", nil, true},
+		{"3000-2000", nil, true},
+		{"http", nil, true},
+		{"2000-", nil, true},
+		{"2000-3000-4000", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePortRanges(tt.in)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePortRanges(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestPortRangeContains(t *testing.T) {
+	r := PortRange{Start: 2000, End: 3000}
+	for port, want := range map[int]bool{1999: false, 2000: true, 2500: true, 3000: true, 3001: false} {
+		if got := r.Contains(port); got != want {
+			t.Errorf("Contains(%d) = %v, want %v", port, got, want)
+		}
+	}
+}
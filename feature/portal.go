@@ -1,10 +1,73 @@
 package feature
 
+import (
+	"breaker/pkg/ip"
+	"net"
+)
+
 type PortalConfig struct {
 	LoggerConfig `ini:"Logger"`
 	// 不带分组
 	PluginHttpProxy `ini:"DEFAULT,omitempty"`
 	ServerAddr      string `ini:"server_addr"`
+	// BindAddr is the address that proxies and vhost ports listen on. By default,
+	// this value is "0.0.0.0".
+	BindAddr string `ini:"bind_addr"`
+	// AllowPorts are the ranges of remote ports proxies can listen on, e.g.
+	// "2000-3000,3001,4000-50000". Privileged ports below 1024 must be listed to be
+	// allowed, others are all allowed if it's empty. Proxies with remote_port 0 are
+	// allocated a free port of the ranges.
+	AllowPorts string `ini:"allow_ports"`
+	// AllowPortRanges are parsed from AllowPorts.
+	AllowPortRanges []PortRange `ini:"-"`
+	// AuthToken is shared with bridges to verify their login request.
+	AuthToken string `ini:"auth_token"`
+	// AuthMaxTimeDiff is the max seconds between the login timestamp and now,
+	// requests out of the window are rejected as replayed. By default, this value is 900.
+	AuthMaxTimeDiff int64 `ini:"auth_max_time_diff"`
+	// KcpBindAddr is the udp address accepting bridges with protocol kcp,
+	// kcp is disabled if it's empty.
+	KcpBindAddr string `ini:"kcp_bind_addr"`
+	// VhostHTTPPort serves the http proxies routed by domain, it's disabled if it's 0.
+	VhostHTTPPort int `ini:"vhost_http_port"`
+	// VhostHTTPSPort routes the tls connections of https proxies by server name,
+	// it's disabled if it's 0.
+	VhostHTTPSPort int `ini:"vhost_https_port"`
+	// SubdomainHost is the domain that subdomains of http proxies are joined with.
+	SubdomainHost string `ini:"subdomain_host"`
+	// MaxPoolCount limits the pool_count of proxies. By default, this value is 10.
+	MaxPoolCount int `ini:"max_pool_count"`
+	// BandwidthLimit limits the bytes per second of each direction shared by all proxies,
+	// e.g. "10MB". It's unlimited if it's empty.
+	BandwidthLimit string `ini:"bandwidth_limit"`
+	// BandwidthLimitBytes is parsed from BandwidthLimit.
+	BandwidthLimitBytes int64 `ini:"-"`
+	// MaxConnections is the max concurrent user connections of all tcp proxies,
+	// it's unlimited if it's 0. Users of udp, http, https and stcp proxies aren't
+	// counted, udp has no connections and the others are served by shared ports.
+	MaxConnections int `ini:"max_connections"`
+	// AllowIPs and DenyIPs are CIDR lists checking the user connections of all tcp proxies,
+	// they're checked before the lists of proxies.
+	AllowIPs []string `ini:"allow_ips" delim:","`
+	DenyIPs  []string `ini:"deny_ips" delim:","`
+	// ACL is parsed from AllowIPs and DenyIPs, it's nil if both are empty.
+	ACL             *ip.ACL `ini:"-"`
+	AdminConfig     `ini:"DEFAULT,omitempty"`
+	KCPConfig       `ini:"DEFAULT,omitempty"`
+	PortalTLSConfig `ini:"DEFAULT,omitempty"`
+}
+
+// PortalTLSConfig is used to accept tls connections from bridges.
+// The portal always accepts tls, with a self-signed certificate if
+// TLSCertFile and TLSKeyFile are empty.
+type PortalTLSConfig struct {
+	TLSCertFile string `ini:"tls_cert_file"`
+	TLSKeyFile  string `ini:"tls_key_file"`
+	// TLSTrustedCaFile enables mutual tls, bridges must present a certificate signed by it.
+	// TLSOnly is forced by it, otherwise bridges could skip the check by plain connections.
+	TLSTrustedCaFile string `ini:"tls_trusted_ca_file"`
+	// TLSOnly rejects bridges connecting without tls.
+	TLSOnly bool `ini:"tls_only"`
 }
 
 func (c *PortalConfig) OnInit() {
@@ -13,4 +76,58 @@ func (c *PortalConfig) OnInit() {
 	if c.ServerAddr == "" {
 		c.ServerAddr = "0.0.0.0:80"
 	}
+	if c.BindAddr == "" {
+		c.BindAddr = "0.0.0.0"
+	}
+	if net.ParseIP(c.BindAddr) == nil {
+		panic("invalid bind_addr:" + c.BindAddr)
+	}
+	ranges, err := ParsePortRanges(c.AllowPorts)
+	if err != nil {
+		panic(err.Error())
+	}
+	c.AllowPortRanges = ranges
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		panic("tls_cert_file and tls_key_file must be set together")
+	}
+	if c.TLSTrustedCaFile != "" {
+		c.TLSOnly = true
+	}
+	if c.AuthMaxTimeDiff < 0 {
+		panic("invalid auth_max_time_diff, can't less than 0")
+	}
+	if c.AuthMaxTimeDiff == 0 {
+		c.AuthMaxTimeDiff = 900
+	}
+	if c.VhostHTTPPort < 0 || c.VhostHTTPPort > 65535 {
+		panic("invalid vhost_http_port[0-65535]")
+	}
+	if c.VhostHTTPSPort < 0 || c.VhostHTTPSPort > 65535 {
+		panic("invalid vhost_https_port[0-65535]")
+	}
+	if c.MaxPoolCount < 0 {
+		panic("invalid max_pool_count, can't less than 0")
+	}
+	if c.MaxPoolCount == 0 {
+		c.MaxPoolCount = 10
+	}
+	if c.BandwidthLimit != "" {
+		limit, err := ParseBandwidth(c.BandwidthLimit)
+		if err != nil {
+			panic(err.Error())
+		}
+		c.BandwidthLimitBytes = limit
+	}
+	if c.MaxConnections < 0 {
+		panic("invalid max_connections, can't less than 0")
+	}
+	if len(c.AllowIPs) > 0 || len(c.DenyIPs) > 0 {
+		acl, err := ip.NewACL(c.AllowIPs, c.DenyIPs)
+		if err != nil {
+			panic(err.Error())
+		}
+		c.ACL = acl
+	}
+	c.AdminConfig.OnInit()
+	c.KCPConfig.OnInit()
 }
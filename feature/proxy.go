@@ -0,0 +1,261 @@
+package feature
+
+import (
+	"breaker/pkg/health"
+	"breaker/pkg/ip"
+	"breaker/pkg/netio"
+	"breaker/pkg/protocol"
+	"fmt"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+const (
+	// ProxySectionPrefix is the prefix of proxy sections in bridge.ini, e.g. [proxy.ssh].
+	ProxySectionPrefix = "proxy."
+	// PluginFileServerName serves the files of [plugin_file_server] instead of the local port.
+	PluginFileServerName = "file_server"
+)
+
+// ProxyConfig is a service exposed by the bridge, it's parsed from the section [proxy.<name>].
+type ProxyConfig struct {
+	// ProxyName is the name of the section without the prefix.
+	ProxyName string `ini:"-"`
+	// Type is the protocol of the local service, valid values are "tcp", "udp", "http", "https" and "stcp".
+	// By default, this value is "tcp".
+	Type string `ini:"type"`
+	// LocalIP is the address of the local service. By default, this value is "127.0.0.1".
+	LocalIP   string `ini:"local_ip"`
+	LocalPort int    `ini:"local_port"`
+	// RemotePort is the port of tcp and udp proxies on the portal, a free port of
+	// allow_ports is allocated by the portal if it's 0.
+	RemotePort int `ini:"remote_port"`
+	// UseEncryption encrypts the tunneled payload with a key derived from auth_token,
+	// so that it's protected even if tls is terminated by an intermediary. It requires
+	// auth_token, or sk for stcp proxies.
+	UseEncryption bool `ini:"use_encryption"`
+	// UseCompression compresses the tunneled payload by deflate, it's ignored if the
+	// portal doesn't support compression.
+	UseCompression bool `ini:"use_compression"`
+	// Plugin serves the work connections in process instead of the local service,
+	// the only valid value is "file_server".
+	Plugin string `ini:"plugin"`
+	// CustomDomains routes the requests of http proxies by the Host header,
+	// and the connections of https proxies by the server name of tls.
+	CustomDomains []string `ini:"custom_domains" delim:","`
+	// SubDomain routes the requests of <subdomain>.<subdomain_host of the portal>.
+	SubDomain string `ini:"subdomain"`
+	// Locations routes the requests of http proxies by path prefix, so that bridges
+	// can share a domain. By default, all paths are routed.
+	Locations []string `ini:"locations" delim:","`
+	// Sk is the secret key of stcp proxies, only visitors with the same key can connect,
+	// it also derives the key of encryption instead of auth_token.
+	Sk string `ini:"sk"`
+	// Group shares the remote port with tcp proxies of other bridges in the same group,
+	// user connections are load balanced between them.
+	Group string `ini:"group"`
+	// GroupKey must be the same among the members of the group.
+	GroupKey string `ini:"group_key"`
+	// GroupStrategy is how the members are picked, valid values are "round_robin"
+	// and "least_conn". By default, this value is "round_robin".
+	GroupStrategy string `ini:"group_strategy"`
+	// HealthCheckType checks the local service, valid values are "tcp" and "http".
+	// The proxy is closed on the portal while the service is down, and registered
+	// again when it recovers. It's disabled if it's empty, udp proxy doesn't support it.
+	HealthCheckType string `ini:"health_check_type"`
+	// HealthCheckPath is requested by http checks, 2xx responses are healthy.
+	// By default, this value is "/".
+	HealthCheckPath string `ini:"health_check_path"`
+	// HealthCheckIntervalS is the seconds between checks. By default, this value is 10.
+	HealthCheckIntervalS int `ini:"health_check_interval_s"`
+	// HealthCheckTimeoutS is the seconds to wait for a check. By default, this value is 3.
+	HealthCheckTimeoutS int `ini:"health_check_timeout_s"`
+	// HealthCheckMaxFailed is the number of continuous failures to close the proxy.
+	// By default, this value is 1.
+	HealthCheckMaxFailed int `ini:"health_check_max_failed"`
+	// PoolCount is the number of work connections dialed in advance, the local service
+	// is dialed only when a work connection is taken by a user. It may be limited by
+	// max_pool_count of the portal. By default, this value is 1.
+	PoolCount int `ini:"pool_count"`
+	// BandwidthLimit limits the bytes per second of each direction, e.g. "1MB" or "512KB".
+	// It's unlimited if it's empty.
+	BandwidthLimit string `ini:"bandwidth_limit"`
+	// BandwidthLimitMode is the side enforcing the limit, valid values are "bridge" and
+	// "portal". By default, this value is "bridge".
+	BandwidthLimitMode string `ini:"bandwidth_limit_mode"`
+	// BandwidthLimitBytes is parsed from BandwidthLimit.
+	BandwidthLimitBytes int64 `ini:"-"`
+	// MaxConnections is the max concurrent user connections of tcp proxies, excess
+	// connections are rejected by the portal. It's unlimited if it's 0.
+	MaxConnections int `ini:"max_connections"`
+	// MaxConnectionsPerIP is the max concurrent user connections of one source ip.
+	MaxConnectionsPerIP int `ini:"max_connections_per_ip"`
+	// MaxConnectionRatePerIP is the max new user connections of one source ip per second.
+	MaxConnectionRatePerIP int `ini:"max_connection_rate_per_ip"`
+	// AllowIPs are CIDRs allowed to connect tcp proxies, e.g. 10.0.0.0/8,192.168.1.1.
+	// Every ip is allowed if it's empty.
+	AllowIPs []string `ini:"allow_ips" delim:","`
+	// DenyIPs are CIDRs denied even if they're allowed.
+	DenyIPs []string `ini:"deny_ips" delim:","`
+	// ProxyProtocolVersion writes a PROXY protocol header of "v1" or "v2" to the local
+	// service, so that it can see the source address of users. It's disabled if it's empty.
+	// For stcp proxies the source is the address of the visitor bridge, not the user
+	// connected to the visitor, since the portal only sees the visitor connection.
+	ProxyProtocolVersion string `ini:"proxy_protocol_version"`
+}
+
+func (p *ProxyConfig) OnInit() {
+	if p.ProxyName == "" {
+		panic("proxy name can not be empty")
+	}
+	if p.Type == "" {
+		p.Type = protocol.ProxyTypeTCP
+	}
+	switch p.Type {
+	case protocol.ProxyTypeTCP, protocol.ProxyTypeUDP:
+	case protocol.ProxyTypeSTCP:
+		if p.Sk == "" {
+			panic(fmt.Sprintf("proxy %s: sk must be set for stcp proxy", p.ProxyName))
+		}
+	case protocol.ProxyTypeHTTP, protocol.ProxyTypeHTTPS:
+		if len(p.CustomDomains) == 0 && p.SubDomain == "" {
+			panic(fmt.Sprintf("proxy %s: custom_domains or subdomain must be set for %s proxy", p.ProxyName, p.Type))
+		}
+		if strings.Contains(p.SubDomain, ".") {
+			panic(fmt.Sprintf("proxy %s: subdomain can not contain '.'", p.ProxyName))
+		}
+	default:
+		panic(fmt.Sprintf("proxy %s: invalid type:%s", p.ProxyName, p.Type))
+	}
+	if p.LocalIP == "" {
+		p.LocalIP = "127.0.0.1"
+	}
+	if p.LocalPort < 0 || p.LocalPort > 65535 {
+		panic(fmt.Sprintf("proxy %s: invalid local port[0-65535]", p.ProxyName))
+	}
+	if p.RemotePort < 0 || p.RemotePort > 65535 {
+		panic(fmt.Sprintf("proxy %s: invalid remote port[0-65535]", p.ProxyName))
+	}
+	if p.Plugin != "" && p.Plugin != PluginFileServerName {
+		panic(fmt.Sprintf("proxy %s: invalid plugin:%s", p.ProxyName, p.Plugin))
+	}
+	if p.Plugin != "" && (p.Type == protocol.ProxyTypeUDP || p.Type == protocol.ProxyTypeHTTPS) {
+		panic(fmt.Sprintf("proxy %s: plugin is not supported by %s proxy", p.ProxyName, p.Type))
+	}
+	if p.Group != "" {
+		if p.Type != protocol.ProxyTypeTCP {
+			panic(fmt.Sprintf("proxy %s: group is only supported by tcp proxy", p.ProxyName))
+		}
+		if p.RemotePort == 0 {
+			panic(fmt.Sprintf("proxy %s: remote_port must be set with group", p.ProxyName))
+		}
+		if p.GroupKey == "" {
+			panic(fmt.Sprintf("proxy %s: group_key must be set with group", p.ProxyName))
+		}
+		if p.GroupStrategy == "" {
+			p.GroupStrategy = protocol.GroupStrategyRoundRobin
+		}
+		if p.GroupStrategy != protocol.GroupStrategyRoundRobin && p.GroupStrategy != protocol.GroupStrategyLeastConn {
+			panic(fmt.Sprintf("proxy %s: invalid group_strategy:%s", p.ProxyName, p.GroupStrategy))
+		}
+	}
+	if p.HealthCheckType != "" {
+		p.initHealthCheck()
+	}
+	if p.PoolCount < 0 {
+		panic(fmt.Sprintf("proxy %s: invalid pool_count, can't less than 0", p.ProxyName))
+	}
+	if p.PoolCount == 0 {
+		p.PoolCount = 1
+	}
+	if p.BandwidthLimit != "" {
+		limit, err := ParseBandwidth(p.BandwidthLimit)
+		if err != nil {
+			panic(fmt.Sprintf("proxy %s: %s", p.ProxyName, err))
+		}
+		p.BandwidthLimitBytes = limit
+	}
+	if p.MaxConnections < 0 || p.MaxConnectionsPerIP < 0 || p.MaxConnectionRatePerIP < 0 {
+		panic(fmt.Sprintf("proxy %s: invalid connection limits, can't less than 0", p.ProxyName))
+	}
+	if (p.MaxConnections > 0 || p.MaxConnectionsPerIP > 0 || p.MaxConnectionRatePerIP > 0) &&
+		p.Type != protocol.ProxyTypeTCP {
+		panic(fmt.Sprintf("proxy %s: connection limits are only supported by tcp proxy", p.ProxyName))
+	}
+	if len(p.AllowIPs) > 0 || len(p.DenyIPs) > 0 {
+		if p.Type != protocol.ProxyTypeTCP {
+			panic(fmt.Sprintf("proxy %s: allow_ips and deny_ips are only supported by tcp proxy", p.ProxyName))
+		}
+		if _, err := ip.NewACL(p.AllowIPs, p.DenyIPs); err != nil {
+			panic(fmt.Sprintf("proxy %s: %s", p.ProxyName, err))
+		}
+	}
+	if p.ProxyProtocolVersion != "" {
+		if p.ProxyProtocolVersion != netio.ProxyProtocolV1 && p.ProxyProtocolVersion != netio.ProxyProtocolV2 {
+			panic(fmt.Sprintf("proxy %s: invalid proxy_protocol_version:%s", p.ProxyName, p.ProxyProtocolVersion))
+		}
+		if p.Type != protocol.ProxyTypeTCP && p.Type != protocol.ProxyTypeSTCP {
+			panic(fmt.Sprintf("proxy %s: proxy_protocol_version is only supported by tcp and stcp proxy", p.ProxyName))
+		}
+		if p.Plugin != "" {
+			panic(fmt.Sprintf("proxy %s: proxy_protocol_version is not supported by plugin", p.ProxyName))
+		}
+	}
+	if p.BandwidthLimitMode == "" {
+		p.BandwidthLimitMode = protocol.BandwidthLimitModeBridge
+	}
+	if p.BandwidthLimitMode != protocol.BandwidthLimitModeBridge && p.BandwidthLimitMode != protocol.BandwidthLimitModePortal {
+		panic(fmt.Sprintf("proxy %s: invalid bandwidth_limit_mode:%s", p.ProxyName, p.BandwidthLimitMode))
+	}
+	if p.Plugin == "" && p.LocalPort == 0 {
+		panic(fmt.Sprintf("proxy %s: local port can not be empty", p.ProxyName))
+	}
+}
+
+func (p *ProxyConfig) initHealthCheck() {
+	if p.HealthCheckType != health.CheckTypeTCP && p.HealthCheckType != health.CheckTypeHTTP {
+		panic(fmt.Sprintf("proxy %s: invalid health_check_type:%s", p.ProxyName, p.HealthCheckType))
+	}
+	if p.Plugin != "" {
+		panic(fmt.Sprintf("proxy %s: health check is not supported by plugin", p.ProxyName))
+	}
+	if p.Type == protocol.ProxyTypeUDP {
+		panic(fmt.Sprintf("proxy %s: health check is not supported by udp proxy", p.ProxyName))
+	}
+	if p.HealthCheckPath == "" {
+		p.HealthCheckPath = "/"
+	}
+	if !strings.HasPrefix(p.HealthCheckPath, "/") {
+		p.HealthCheckPath = "/" + p.HealthCheckPath
+	}
+	if p.HealthCheckIntervalS < 0 || p.HealthCheckTimeoutS < 0 || p.HealthCheckMaxFailed < 0 {
+		panic(fmt.Sprintf("proxy %s: health check options can't less than 0", p.ProxyName))
+	}
+	if p.HealthCheckIntervalS == 0 {
+		p.HealthCheckIntervalS = 10
+	}
+	if p.HealthCheckTimeoutS == 0 {
+		p.HealthCheckTimeoutS = 3
+	}
+	if p.HealthCheckMaxFailed == 0 {
+		p.HealthCheckMaxFailed = 1
+	}
+}
+
+// loadProxySections maps every [proxy.<name>] section to a ProxyConfig.
+func loadProxySections(f *ini.File) (map[string]*ProxyConfig, error) {
+	proxies := make(map[string]*ProxyConfig)
+	for _, section := range f.Sections() {
+		if !strings.HasPrefix(section.Name(), ProxySectionPrefix) {
+			continue
+		}
+		pc := &ProxyConfig{}
+		if err := section.MapTo(pc); err != nil {
+			return nil, fmt.Errorf("section %s: %s", section.Name(), err)
+		}
+		pc.ProxyName = strings.TrimPrefix(section.Name(), ProxySectionPrefix)
+		proxies[pc.ProxyName] = pc
+	}
+	return proxies, nil
+}
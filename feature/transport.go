@@ -0,0 +1,61 @@
+package feature
+
+import "breaker/pkg/kcp"
+
+// KCPConfig tunes the kcp transport, the portal and bridges are expected to use the same values.
+type KCPConfig struct {
+	// KcpSndWnd and KcpRcvWnd are the max send and receive window in packets,
+	// larger windows carry more data on links with high latency.
+	// By default, they are 128 and 512.
+	KcpSndWnd int `ini:"kcp_snd_wnd"`
+	KcpRcvWnd int `ini:"kcp_rcv_wnd"`
+	// KcpInterval is the update interval in milliseconds, a lower value resends
+	// lost packets faster but costs more cpu. By default, this value is 20.
+	KcpInterval int `ini:"kcp_interval"`
+	// KcpMtu is the max size of udp packets. By default, this value is 1350.
+	KcpMtu int `ini:"kcp_mtu"`
+	// KcpMaxConns is the max kcp connections accepted by the portal, including the
+	// work connections of all bridges. By default, this value is 1024.
+	KcpMaxConns int `ini:"kcp_max_conns"`
+}
+
+func (k *KCPConfig) OnInit() {
+	def := kcp.DefaultConfig()
+	if k.KcpSndWnd == 0 {
+		k.KcpSndWnd = def.SndWnd
+	}
+	if k.KcpRcvWnd == 0 {
+		k.KcpRcvWnd = def.RcvWnd
+	}
+	if k.KcpInterval == 0 {
+		k.KcpInterval = def.Interval
+	}
+	if k.KcpMtu == 0 {
+		k.KcpMtu = def.MTU
+	}
+	if k.KcpMaxConns == 0 {
+		k.KcpMaxConns = def.MaxConns
+	}
+	if k.KcpMaxConns < 0 {
+		panic("invalid kcp_max_conns, can't less than 0")
+	}
+	if k.KcpSndWnd < 0 || k.KcpRcvWnd < 0 {
+		panic("invalid kcp window, can't less than 0")
+	}
+	if k.KcpInterval < 10 || k.KcpInterval > 5000 {
+		panic("invalid kcp_interval[10-5000]")
+	}
+	if k.KcpMtu < 50 || k.KcpMtu > 65000 {
+		panic("invalid kcp_mtu[50-65000]")
+	}
+}
+
+func (k *KCPConfig) ToKCPConfig() *kcp.Config {
+	config := kcp.DefaultConfig()
+	config.SndWnd = k.KcpSndWnd
+	config.RcvWnd = k.KcpRcvWnd
+	config.Interval = k.KcpInterval
+	config.MTU = k.KcpMtu
+	config.MaxConns = k.KcpMaxConns
+	return config
+}
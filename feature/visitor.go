@@ -0,0 +1,72 @@
+package feature
+
+import (
+	"breaker/pkg/protocol"
+	"fmt"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// VisitorSectionPrefix is the prefix of visitor sections in bridge.ini, e.g. [visitor.ssh].
+const VisitorSectionPrefix = "visitor."
+
+// VisitorConfig listens on the bridge and connects to a stcp proxy of another
+// bridge, it's parsed from the section [visitor.<name>].
+type VisitorConfig struct {
+	// VisitorName is the name of the section without the prefix.
+	VisitorName string `ini:"-"`
+	// Type is the type of the proxy to visit, the only valid value is "stcp".
+	Type string `ini:"type"`
+	// ServerName is the name of the stcp proxy to visit.
+	ServerName string `ini:"server_name"`
+	// Sk is the secret key of the stcp proxy.
+	Sk string `ini:"sk"`
+	// BindAddr is the local address of the visitor. By default, this value is "127.0.0.1".
+	BindAddr string `ini:"bind_addr"`
+	BindPort int    `ini:"bind_port"`
+	// UseEncryption and UseCompression must be the same as the stcp proxy.
+	UseEncryption  bool `ini:"use_encryption"`
+	UseCompression bool `ini:"use_compression"`
+}
+
+func (v *VisitorConfig) OnInit() {
+	if v.VisitorName == "" {
+		panic("visitor name can not be empty")
+	}
+	if v.Type == "" {
+		v.Type = protocol.ProxyTypeSTCP
+	}
+	if v.Type != protocol.ProxyTypeSTCP {
+		panic(fmt.Sprintf("visitor %s: invalid type:%s", v.VisitorName, v.Type))
+	}
+	if v.ServerName == "" {
+		panic(fmt.Sprintf("visitor %s: server_name can not be empty", v.VisitorName))
+	}
+	if v.Sk == "" {
+		panic(fmt.Sprintf("visitor %s: sk can not be empty", v.VisitorName))
+	}
+	if v.BindAddr == "" {
+		v.BindAddr = "127.0.0.1"
+	}
+	if v.BindPort <= 0 || v.BindPort > 65535 {
+		panic(fmt.Sprintf("visitor %s: invalid bind port[1-65535]", v.VisitorName))
+	}
+}
+
+// loadVisitorSections maps every [visitor.<name>] section to a VisitorConfig.
+func loadVisitorSections(f *ini.File) (map[string]*VisitorConfig, error) {
+	visitors := make(map[string]*VisitorConfig)
+	for _, section := range f.Sections() {
+		if !strings.HasPrefix(section.Name(), VisitorSectionPrefix) {
+			continue
+		}
+		vc := &VisitorConfig{}
+		if err := section.MapTo(vc); err != nil {
+			return nil, fmt.Errorf("section %s: %s", section.Name(), err)
+		}
+		vc.VisitorName = strings.TrimPrefix(section.Name(), VisitorSectionPrefix)
+		visitors[vc.VisitorName] = vc
+	}
+	return visitors, nil
+}
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+)
+
+// Server serves the admin api as json, every request must carry the basic
+// auth credentials of User and Password.
+type Server struct {
+	User     string
+	Password string
+	mux      *http.ServeMux
+}
+
+func NewServer(user, password string) *Server {
+	return &Server{
+		User:     user,
+		Password: password,
+		mux:      http.NewServeMux(),
+	}
+}
+
+// HandleFunc registers handlers of the path by http method, other methods are rejected.
+func (s *Server) HandleFunc(path string, handlers map[string]http.HandlerFunc) {
+	s.mux.HandleFunc(path, func(rw http.ResponseWriter, req *http.Request) {
+		handler, ok := handlers[req.Method]
+		if !ok {
+			WriteError(rw, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(rw, req)
+	})
+}
+
+// Handle registers handler of the path for GET requests, e.g. the metrics.
+func (s *Server) Handle(path string, handler http.Handler) {
+	s.HandleFunc(path, map[string]http.HandlerFunc{
+		http.MethodGet: handler.ServeHTTP,
+	})
+}
+
+func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	user, password, ok := req.BasicAuth()
+	if !ok || !equal(user, s.User) || !equal(password, s.Password) {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="breaker"`)
+		WriteError(rw, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	s.mux.ServeHTTP(rw, req)
+}
+
+// Serve accepts admin requests on l, it blocks until l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	server := &http.Server{
+		Handler: s,
+	}
+	return server.Serve(l)
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// WriteJSON writes v as the response body.
+func WriteJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
+
+// WriteError writes {"error": msg} as the response body.
+func WriteError(rw http.ResponseWriter, status int, msg string) {
+	WriteJSON(rw, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,102 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func testServer() *httptest.Server {
+	s := NewServer("admin", "secret")
+	s.HandleFunc("/api/status", map[string]http.HandlerFunc{
+		http.MethodGet: func(rw http.ResponseWriter, req *http.Request) {
+			WriteJSON(rw, http.StatusOK, map[string]string{"status": "ok"})
+		},
+	})
+	return httptest.NewServer(s)
+}
+
+func TestServerAuth(t *testing.T) {
+	srv := testServer()
+	defer srv.Close()
+	tests := []struct {
+		name, user, password string
+		auth                 bool
+		status               int
+	}{
+		{"no credentials", "", "", false, http.StatusUnauthorized},
+		{"right credentials", "admin", "secret", true, http.StatusOK},
+		{"wrong user", "root", "secret", true, http.StatusUnauthorized},
+		{"wrong password", "admin", "secrets", true, http.StatusUnauthorized},
+		{"prefix of password", "admin", "sec", true, http.StatusUnauthorized},
+		{"empty password", "admin", "", true, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/status", nil)
+		if tt.auth {
+			req.SetBasicAuth(tt.user, tt.password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+		if tt.status == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate header", tt.name)
+		}
+	}
+}
+
+func TestServerRoutes(t *testing.T) {
+	srv := testServer()
+	defer srv.Close()
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/api/status", http.StatusOK},
+		{http.MethodPost, "/api/status", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/api/unknown", http.StatusNotFound},
+		{http.MethodGet, "/", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		req.SetBasicAuth("admin", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body map[string]string
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+		if tt.status == http.StatusMethodNotAllowed && body["error"] == "" {
+			t.Errorf("%s %s: no error in the body", tt.method, tt.path)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"secret", "secret", true},
+		{"", "", true},
+		{"secret", "Secret", false},
+		{"secret", "secret ", false},
+		{"sec", "secret", false},
+		{"", "secret", false},
+	}
+	for _, tt := range tests {
+		if got := equal(tt.a, tt.b); got != tt.want {
+			t.Errorf("equal(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
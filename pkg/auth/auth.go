@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid privilege key")
+	ErrExpired    = errors.New("timestamp is out of the allowed window")
+	ErrReplayed   = errors.New("privilege key has been used")
+)
+
+// NewNonce returns a random hex string making the privilege keys of the same second unique.
+func NewNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// GetPrivilegeKey returns hex encoded HMAC-SHA256 of timestamp and nonce keyed by token.
+func GetPrivilegeKey(token string, timestamp int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + ":" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifier checks privilege keys sent by bridges.
+// A key is accepted only when its timestamp is within the window and its nonce has not been seen before.
+type Verifier struct {
+	token  string
+	window time.Duration
+
+	lock sync.Mutex
+	// seen records accepted nonces until their timestamp leaves the window
+	seen map[string]time.Time
+}
+
+func NewVerifier(token string, window time.Duration) *Verifier {
+	return &Verifier{
+		token:  token,
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// VerifyPrivilegeKey checks the key and the window of timestamp, keys are not
+// remembered so it's up to the caller to deal with replays.
+func VerifyPrivilegeKey(token string, timestamp int64, nonce string, key string, window time.Duration) error {
+	now := time.Now()
+	ts := time.Unix(timestamp, 0)
+	if window > 0 && (now.Sub(ts) > window || ts.Sub(now) > window) {
+		return ErrExpired
+	}
+	if nonce == "" {
+		return ErrInvalidKey
+	}
+	expected := GetPrivilegeKey(token, timestamp, nonce)
+	if !hmac.Equal([]byte(expected), []byte(key)) {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+func (v *Verifier) Verify(timestamp int64, nonce string, key string) error {
+	if err := VerifyPrivilegeKey(v.token, timestamp, nonce, key, v.window); err != nil {
+		return err
+	}
+	now := time.Now()
+	ts := time.Unix(timestamp, 0)
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for k, t := range v.seen {
+		if now.Sub(t) > v.window {
+			delete(v.seen, k)
+		}
+	}
+	if _, ok := v.seen[nonce]; ok {
+		return ErrReplayed
+	}
+	v.seen[nonce] = ts
+	return nil
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifierSameSecond(t *testing.T) {
+	v := NewVerifier("token", time.Minute)
+	ts := time.Now().Unix()
+	// several bridges sharing the token log in within the same second
+	for i := 0; i < 3; i++ {
+		nonce := NewNonce()
+		if err := v.Verify(ts, nonce, GetPrivilegeKey("token", ts, nonce)); err != nil {
+			t.Fatalf("login %d: %s", i, err)
+		}
+	}
+}
+
+func TestVerifierRejects(t *testing.T) {
+	v := NewVerifier("token", time.Minute)
+	ts := time.Now().Unix()
+	nonce := NewNonce()
+	key := GetPrivilegeKey("token", ts, nonce)
+	if err := v.Verify(ts, nonce, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(ts, nonce, key); err != ErrReplayed {
+		t.Fatalf("got %v, want ErrReplayed", err)
+	}
+	other := NewNonce()
+	if err := v.Verify(ts, other, GetPrivilegeKey("wrong", ts, other)); err != ErrInvalidKey {
+		t.Fatalf("got %v, want ErrInvalidKey", err)
+	}
+	if err := v.Verify(ts, "", GetPrivilegeKey("token", ts, "")); err != ErrInvalidKey {
+		t.Fatalf("got %v, want ErrInvalidKey", err)
+	}
+	old := ts - 120
+	if err := v.Verify(old, other, GetPrivilegeKey("token", old, other)); err != ErrExpired {
+		t.Fatalf("got %v, want ErrExpired", err)
+	}
+}
@@ -0,0 +1,295 @@
+package binpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+var (
+	ErrUnsupportedType = errors.New("binpack: unsupported type")
+	ErrInvalidFormat   = errors.New("binpack: invalid format")
+	ErrNotPointer      = errors.New("binpack: decode target must be a non-nil pointer")
+)
+
+// Marshal returns the compact binary encoding of v.
+//
+// The format carries no field names or type information: struct fields are written in declaration order,
+// integers as varints, strings/slices/maps with a length prefix. Both ends must therefore share the same
+// type definition, in exchange the output is much smaller than json or msgpack.
+// Interfaces, channels and funcs are not supported.
+func Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil, fmt.Errorf("%w: nil", ErrUnsupportedType)
+	}
+	// top level pointers are dereferenced to match Unmarshal, which always decodes into a pointer
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := encode(buf, rv); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes data produced by Marshal into v, v must be a non-nil pointer.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrNotPointer
+	}
+	r := bytes.NewReader(data)
+	if err := decode(r, rv.Elem()); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidFormat, r.Len())
+	}
+	return nil
+}
+
+func putUvarint(w *bytes.Buffer, n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func encode(w *bytes.Buffer, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var b [binary.MaxVarintLen64]byte
+		w.Write(b[:binary.PutVarint(b[:], v.Int())])
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		putUvarint(w, v.Uint())
+	case reflect.Float32:
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], math.Float32bits(float32(v.Float())))
+		w.Write(b[:])
+	case reflect.Float64:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(v.Float()))
+		w.Write(b[:])
+	case reflect.String:
+		putUvarint(w, uint64(v.Len()))
+		w.WriteString(v.String())
+	case reflect.Slice:
+		// length is shifted by one so that nil and empty slices stay distinguishable
+		if v.IsNil() {
+			putUvarint(w, 0)
+			return nil
+		}
+		putUvarint(w, uint64(v.Len())+1)
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			w.Write(v.Bytes())
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := encode(w, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := encode(w, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			putUvarint(w, 0)
+			return nil
+		}
+		putUvarint(w, uint64(v.Len())+1)
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := encode(w, iter.Key()); err != nil {
+				return err
+			}
+			if err := encode(w, iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			w.WriteByte(0)
+			return nil
+		}
+		w.WriteByte(1)
+		return encode(w, v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !exported(t.Field(i)) {
+				continue
+			}
+			if err := encode(w, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, v.Type())
+	}
+	return nil
+}
+
+// exported reports whether the struct field takes part in encoding.
+func exported(f reflect.StructField) bool {
+	if f.Tag.Get("binpack") == "-" {
+		return false
+	}
+	return f.PkgPath == ""
+}
+
+// readLen reads a length prefix, shift is 1 for the nil-aware prefix of slices and maps.
+func readLen(r *bytes.Reader, shift uint64) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, ErrInvalidFormat
+	}
+	if n > uint64(r.Len())+shift {
+		return 0, fmt.Errorf("%w: length %d exceeds input", ErrInvalidFormat, n)
+	}
+	return int(n), nil
+}
+
+func decode(r *bytes.Reader, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := r.ReadByte()
+		if err != nil {
+			return ErrInvalidFormat
+		}
+		v.SetBool(b != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := binary.ReadVarint(r)
+		if err != nil {
+			return ErrInvalidFormat
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("%w: %d overflows %s", ErrInvalidFormat, n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return ErrInvalidFormat
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("%w: %d overflows %s", ErrInvalidFormat, n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.Float32:
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return ErrInvalidFormat
+		}
+		v.SetFloat(float64(math.Float32frombits(n)))
+	case reflect.Float64:
+		var n uint64
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return ErrInvalidFormat
+		}
+		v.SetFloat(math.Float64frombits(n))
+	case reflect.String:
+		n, err := readLen(r, 0)
+		if err != nil {
+			return err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return ErrInvalidFormat
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		n, err := readLen(r, 1)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		n--
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, n)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return ErrInvalidFormat
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := decode(r, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := decode(r, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, err := readLen(r, 1)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		t := v.Type()
+		m := reflect.MakeMapWithSize(t, n-1)
+		for i := 0; i < n-1; i++ {
+			key := reflect.New(t.Key()).Elem()
+			if err := decode(r, key); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := decode(r, elem); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+		}
+		v.Set(m)
+	case reflect.Ptr:
+		b, err := r.ReadByte()
+		if err != nil {
+			return ErrInvalidFormat
+		}
+		if b == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decode(r, v.Elem())
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if !exported(t.Field(i)) {
+				continue
+			}
+			if err := decode(r, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, v.Type())
+	}
+	return nil
+}
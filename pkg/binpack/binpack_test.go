@@ -0,0 +1,82 @@
+package binpack
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type Inner struct {
+	Name string
+}
+
+type sample struct {
+	Inner
+	Bool    bool
+	Int     int
+	Int64   int64
+	Uint64  uint64
+	Float32 float32
+	Float64 float64
+	Bytes   []byte
+	Empty   []string
+	Nil     []string
+	Strings []string
+	Map     map[string]int
+	Ptr     *Inner
+	NilPtr  *Inner
+	Array   [2]uint8
+	Skipped string `binpack:"-"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := sample{
+		Inner:   Inner{Name: "proxy"},
+		Bool:    true,
+		Int:     -1 << 40,
+		Int64:   math.MinInt64,
+		Uint64:  math.MaxUint64,
+		Float32: 1.5,
+		Float64: -2.25,
+		Bytes:   []byte{0, 1, 2},
+		Empty:   []string{},
+		Strings: []string{"", "a"},
+		Map:     map[string]int{"a": 1, "b": -200},
+		Ptr:     &Inner{Name: "ptr"},
+		Array:   [2]uint8{1, 2},
+		Skipped: "skipped",
+	}
+	data, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out sample
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Skipped = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch:\n%+v\n%+v", in, out)
+	}
+	if out.Empty == nil || out.Nil != nil {
+		t.Fatal("nil and empty slices are not distinguished")
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	data, err := Marshal(Inner{Name: "proxy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Inner
+	if err := Unmarshal(data[:len(data)-1], &out); err == nil {
+		t.Fatal("truncated data is decoded")
+	}
+	if err := Unmarshal(append(data, 0), &out); !errors.Is(err, ErrInvalidFormat) {
+		t.Fatalf("got %v, want ErrInvalidFormat", err)
+	}
+	if _, err := Marshal(make(chan int)); !errors.Is(err, ErrUnsupportedType) {
+		t.Fatalf("got %v, want ErrUnsupportedType", err)
+	}
+}
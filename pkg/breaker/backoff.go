@@ -0,0 +1,43 @@
+package breaker
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff returns exponentially growing delays with jitter, so that bridges
+// don't reconnect at the same moment after the portal restarts.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	attempt uint
+	rand    *rand.Rand
+}
+
+func newBackoff(initial, max time.Duration) *backoff {
+	return &backoff{
+		initial: initial,
+		max:     max,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next returns a delay in [d/2, d), d is initial*2^attempt limited by max.
+func (b *backoff) Next() time.Duration {
+	d := b.max
+	if b.attempt < 32 {
+		if exp := b.initial << b.attempt; exp > 0 && exp < b.max {
+			d = exp
+		}
+	}
+	b.attempt++
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(b.rand.Int63n(int64(half)))
+}
+
+func (b *backoff) Reset() {
+	b.attempt = 0
+}
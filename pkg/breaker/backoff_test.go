@@ -0,0 +1,54 @@
+package breaker
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestBackoffNext(t *testing.T) {
+	b := newBackoff(100*time.Millisecond, time.Second)
+	b.rand = rand.New(rand.NewSource(1))
+	limits := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		// capped by max from here on
+		time.Second,
+		time.Second,
+	}
+	for i, d := range limits {
+		if got := b.Next(); got < d/2 || got >= d {
+			t.Fatalf("attempt %d: got %s, want [%s, %s)", i, got, d/2, d)
+		}
+	}
+
+	b.Reset()
+	if got := b.Next(); got < 50*time.Millisecond || got >= 100*time.Millisecond {
+		t.Fatalf("got %s after reset", got)
+	}
+}
+
+func TestBackoffOverflow(t *testing.T) {
+	b := newBackoff(time.Second, time.Hour)
+	// initial<<attempt overflows long before the attempts stop growing
+	for i := 0; i < 100; i++ {
+		if got := b.Next(); got <= 0 || got >= time.Hour {
+			t.Fatalf("attempt %d: got %s", i, got)
+		}
+	}
+	if got := b.Next(); got < 30*time.Minute {
+		t.Fatalf("got %s, want it capped by max", got)
+	}
+}
+
+func TestBackoffTiny(t *testing.T) {
+	// no jitter when half of the delay is 0
+	b := newBackoff(1, 1)
+	for i := 0; i < 3; i++ {
+		if got := b.Next(); got != 1 {
+			t.Fatalf("got %s", got)
+		}
+	}
+}
@@ -2,32 +2,84 @@ package breaker
 
 import (
 	"breaker/feature"
+	"breaker/pkg/auth"
+	"breaker/pkg/mux"
+	"breaker/pkg/netio"
 	"breaker/pkg/protocol"
+	"breaker/pkg/transport"
 	"breaker/plugin"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var ErrClientStopped = errors.New("client stopped")
+var (
+	ErrClientStopped = errors.New("client stopped")
+	ErrAuthFailed    = errors.New("authentication failed")
+	ErrGaveUp        = errors.New("gave up reconnecting")
+	// ErrMuxUnsupported is returned by login if the server replies that mux is not supported
+	ErrMuxUnsupported = errors.New("mux is not supported by the server")
+	// ErrNotLoggedIn is returned by work connections dialed before the first login
+	ErrNotLoggedIn = errors.New("not logged in")
+)
+
+const (
+	defaultReconnectInitialInterval = time.Second
+	defaultReconnectMaxInterval     = time.Minute
+)
+
+// ClientState is the state of the connection to the server.
+type ClientState int
+
+const (
+	StateDisconnected ClientState = iota
+	StateConnecting
+	StateConnected
+	// StateGaveUp means the client stops reconnecting after the max retries.
+	StateGaveUp
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateGaveUp:
+		return "gave up"
+	default:
+		return "unknown"
+	}
+}
 
 type Client struct {
 	Conf *feature.BridgeConfig
 	// Packer is the message packer, will be passed to Session.
 	Packer Packer
 
-	// Codec is the message codec, will be passed to Session.
+	// Codec is the preferred message codec of the master Session,
+	// the login handshake and work connections always use DefaultCodec.
 	Codec Codec
 
+	// Capability is announced to the server during login.
+	Capability protocol.Capability
+
 	// OnSessionCreate is an event hook, will be invoked when Session's created.
 	OnSessionCreate func(sess Session)
 
 	// OnSessionClose is an event hook, will be invoked when Session's closed.
 	OnSessionClose func(sess Session)
 
+	// OnStateChange is an event hook, will be invoked when the state of the connection changes.
+	OnStateChange func(state ClientState)
+
 	socketReadBufferSize  int
 	socketWriteBufferSize int
 	readTimeout           time.Duration
@@ -36,8 +88,35 @@ type Client struct {
 	router                *Router
 	stopped               chan struct{}
 	writeAttemptTimes     int
-	Session               *TcpSession
 	FileServer            *plugin.FileServer
+	tlsConfig             *tls.Config
+	muxEnabled            bool
+	muxSession            *mux.Session
+	muxLock               sync.Mutex
+	transport             transport.Transport
+	servers               *ServerList
+	// serverAddr is the server connected by the last login, work connections are dialed to it
+	serverAddr       string
+	state            ClientState
+	stateLock        sync.Mutex
+	reconnectInitial time.Duration
+	reconnectMax     time.Duration
+	// reconnectMaxRetries is the number of reconnecting attempts before giving up, 0 means forever
+	reconnectMaxRetries int
+	// loginFailExit makes Start return if the first login fails, otherwise it keeps reconnecting
+	loginFailExit bool
+	// proxies are the configured proxies by name, they're replaced by UpdateProxies
+	proxies map[string]*feature.ProxyConfig
+	// phases are the registration results of proxies
+	phases map[string]proxyPhase
+	// unhealthy are the proxies withdrawn by health checks, they're not registered until they recover
+	unhealthy map[string]bool
+	proxyLock sync.Mutex
+	// session is the master session of the last login and negotiated is the capability
+	// agreed in it, they're replaced on every reconnect
+	session     *TcpSession
+	negotiated  protocol.Capability
+	sessionLock sync.RWMutex
 }
 
 func NewClient(opts ...ClientOption) *Client {
@@ -46,34 +125,144 @@ func NewClient(opts ...ClientOption) *Client {
 		stopped:           make(chan struct{}),
 		Packer:            NewDefaultPacker(),
 		Codec:             NewDefaultCodec(),
+		Capability:        protocol.LocalCapability(),
 		router:            NewRouter(),
 		respQueueSize:     QueueSize,
 		writeAttemptTimes: DefaultWriteAttemptTimes,
+		transport:         transport.NewTCPTransport(),
+		phases:            make(map[string]proxyPhase),
+		unhealthy:         make(map[string]bool),
+		reconnectInitial:  defaultReconnectInitialInterval,
+		reconnectMax:      defaultReconnectMaxInterval,
+		loginFailExit:     true,
 	}
 	for _, opt := range opts {
 		opt(srv)
 	}
+	srv.proxies = make(map[string]*feature.ProxyConfig)
+	if srv.Conf != nil {
+		for name, pc := range srv.Conf.Proxies {
+			srv.proxies[name] = pc
+		}
+	}
 	return srv
 }
+
+// Connect tries the servers one by one until it logs in to one of them.
 func (s *Client) Connect() error {
-	conn, err := net.Dial("tcp", s.Conf.ServerAddr)
+	servers := s.servers
+	if servers == nil {
+		servers = NewServerList(s.Conf.ServerAddrs, s.Conf.ServerSRV, false)
+	}
+	addrs, err := servers.Candidates()
 	if err != nil {
 		return err
 	}
-
-	if s.socketReadBufferSize > 0 {
-		if err := conn.(*net.TCPConn).SetReadBuffer(s.socketReadBufferSize); err != nil {
-			return fmt.Errorf("conn set read buffer err: %s", err)
+	for _, addr := range addrs {
+		s.setServerAddr(addr)
+		if err = s.connectServer(); err == nil {
+			return nil
 		}
+		log.Errorf("connect server:[%s] err: %s", addr, err)
 	}
-	if s.socketWriteBufferSize > 0 {
-		if err := conn.(*net.TCPConn).SetWriteBuffer(s.socketWriteBufferSize); err != nil {
-			return fmt.Errorf("conn set write buffer err: %s", err)
+	return err
+}
+
+func (s *Client) connectServer() error {
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	log.Infof("start %s client:%s", s.Conf.Protocol, s.ServerAddr())
+
+	err = s.login(conn)
+	if err == nil && s.muxEnabled && !s.Negotiated().Mux {
+		s.Session().Close()
+		err = ErrMuxUnsupported
+	}
+	if errors.Is(err, ErrMuxUnsupported) {
+		// only an explicit reply turns mux off, other errors are retried with mux
+		log.Warnf("%s, retry without mux", err)
+		s.closeMux()
+		s.muxEnabled = false
+		return s.connectServer()
+	}
+	return err
+}
+
+// ServerAddr returns the address of the server in use.
+func (s *Client) ServerAddr() string {
+	s.muxLock.Lock()
+	defer s.muxLock.Unlock()
+	return s.serverAddr
+}
+
+// setServerAddr switches to the server, the mux session of the previous one is closed.
+func (s *Client) setServerAddr(addr string) {
+	s.muxLock.Lock()
+	defer s.muxLock.Unlock()
+	if s.serverAddr == addr {
+		return
+	}
+	s.serverAddr = addr
+	if s.muxSession != nil {
+		s.muxSession.Close()
+		s.muxSession = nil
+	}
+}
+
+// dial returns a new connection to the server,
+// it's a stream of the shared mux session if mux is enabled.
+func (s *Client) dial() (net.Conn, error) {
+	if !s.muxEnabled {
+		return s.dialConn(s.ServerAddr())
+	}
+	s.muxLock.Lock()
+	defer s.muxLock.Unlock()
+	if s.muxSession == nil || s.muxSession.IsClosed() {
+		conn, err := s.dialConn(s.serverAddr)
+		if err != nil {
+			return nil, err
 		}
+		s.muxSession = mux.Client(conn, nil)
+	}
+	return s.muxSession.Open()
+}
+
+func (s *Client) closeMux() {
+	s.muxLock.Lock()
+	defer s.muxLock.Unlock()
+	if s.muxSession != nil {
+		s.muxSession.Close()
+		s.muxSession = nil
 	}
-	log.Infof("start tcp client:" + s.Conf.ServerAddr)
+}
 
-	return s.login(conn)
+// dialConn dials a physical connection to addr, it's wrapped by tls if tls is enabled.
+func (s *Client) dialConn(addr string) (net.Conn, error) {
+	conn, err := s.transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSocketBuffer(conn, s.socketReadBufferSize, s.socketWriteBufferSize); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if s.tlsConfig == nil {
+		return conn, nil
+	}
+	tlsConfig := s.tlsConfig
+	if tlsConfig.ServerName == "" {
+		// verify the certificate by the host of the server in use
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake err: %s", err)
+	}
+	return tlsConn, nil
 }
 
 func (s *Client) IsStopped() bool {
@@ -85,22 +274,22 @@ func (s *Client) IsStopped() bool {
 	}
 }
 
-func (s *Client) handleSession() {
+func (s *Client) handleSession(session *TcpSession) {
 
 	if s.OnSessionCreate != nil {
-		go s.OnSessionCreate(s.Session)
+		go s.OnSessionCreate(session)
 	}
 
-	go s.Session.readInbound(s.router, s.readTimeout)               // start reading message packet from connection.
-	go s.Session.writeOutbound(s.writeTimeout, s.writeAttemptTimes) // start writing message packet to connection.
+	go session.readInbound(s.router, s.readTimeout)               // start reading message packet from connection.
+	go session.writeOutbound(s.writeTimeout, s.writeAttemptTimes) // start writing message packet to connection.
 
 	select {
-	case <-s.Session.closed: // wait for Session finished.
+	case <-session.closed: // wait for Session finished.
 	case <-s.stopped: // or the client is stopped.
 	}
 
 	if s.OnSessionClose != nil {
-		go s.OnSessionClose(s.Session)
+		go s.OnSessionClose(session)
 	}
 }
 func (s *Client) AddRoute(cmd protocol.Command, handler HandlerFunc, middlewares ...MiddlewareFunc) {
@@ -120,20 +309,85 @@ func (s *Client) NotFoundHandler(handler HandlerFunc) {
 // Stop stops client. Closing Listener and all connections.
 func (s *Client) Stop() error {
 	close(s.stopped)
-	s.Session.Close()
+	if session := s.Session(); session != nil {
+		session.Close()
+	}
+	s.closeMux()
 	return nil
 }
 
-func (s *Client) login(c net.Conn) error {
+// Session returns the master session of the last login, it's nil before login.
+func (s *Client) Session() *TcpSession {
+	s.sessionLock.RLock()
+	defer s.sessionLock.RUnlock()
+	return s.session
+}
+
+// Negotiated returns the capability agreed with the server in the last login.
+func (s *Client) Negotiated() protocol.Capability {
+	s.sessionLock.RLock()
+	defer s.sessionLock.RUnlock()
+	return s.negotiated
+}
+
+// current returns the session and its capability together, they're consistent
+// even if a reconnect happens in between.
+func (s *Client) current() (*TcpSession, protocol.Capability) {
+	s.sessionLock.RLock()
+	defer s.sessionLock.RUnlock()
+	return s.session, s.negotiated
+}
+
+func (s *Client) setSession(session *TcpSession, negotiated protocol.Capability) {
+	s.sessionLock.Lock()
+	defer s.sessionLock.Unlock()
+	s.session = session
+	s.negotiated = negotiated
+}
+
+// State returns the state of the connection to the server.
+func (s *Client) State() ClientState {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	return s.state
+}
+
+func (s *Client) setState(state ClientState) {
+	s.stateLock.Lock()
+	changed := s.state != state
+	s.state = state
+	s.stateLock.Unlock()
+	if changed && s.OnStateChange != nil {
+		s.OnStateChange(state)
+	}
+}
+
+func (s *Client) login(c net.Conn) (err error) {
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
 	conn := &MasterConn{
 		Conn: c,
 	}
+	if err := c.SetReadDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
 	session := NewTcpSession(conn,
-		AsCodec(s.Codec),
+		AsCodec(NewDefaultCodec()),
 		AsPacker(s.Packer),
 		AsQueueSize(s.respQueueSize),
 	)
-	err := session.SendCmdSync(&protocol.NewMaster{})
+	capability := s.Capability.PreferCodec(s.Codec.Name())
+	capability.Mux = s.muxEnabled
+	timestamp, nonce := time.Now().Unix(), auth.NewNonce()
+	err = session.SendCmdSync(&protocol.NewMaster{
+		Capability:   capability,
+		Timestamp:    timestamp,
+		PrivilegeKey: auth.GetPrivilegeKey(s.Conf.AuthToken, timestamp, nonce),
+		Nonce:        nonce,
+	})
 	if err != nil {
 		return err
 	}
@@ -145,57 +399,70 @@ func (s *Client) login(c net.Conn) error {
 	if !ok {
 		return fmt.Errorf("cmd is not NewMaster")
 	}
+	if resp.Code == protocol.CodeAuthFailed {
+		return fmt.Errorf("%w: %s", ErrAuthFailed, resp.Error)
+	}
 	if resp.Error != "" {
-		return fmt.Errorf(resp.Error)
+		return errors.New(resp.Error)
+	}
+	if err := protocol.CheckVersion(resp.Version); err != nil {
+		return fmt.Errorf("portal %s", err)
+	}
+	if len(resp.Codecs) == 0 {
+		return protocol.ErrNoCommonCodec
 	}
+	codec, err := CodecByName(resp.Codecs[0])
+	if err != nil {
+		return err
+	}
+	session.SetCodec(codec)
+	session.SetCapability(resp.Capability)
 
 	sessionId := resp.SessionId
 
-	log.Infof("login success,get Session id :[%s]", sessionId)
+	log.Infof("login success,get Session id :[%s],protocol version:[%d],codec:[%s],features:%v",
+		sessionId, resp.Version, codec.Name(), resp.Features)
 
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
 	session.SetID(sessionId)
-	s.Session = session
-	go s.handleSession()
+	s.setSession(session, resp.Capability)
+	go s.handleSession(session)
 	return nil
 }
 
 func (s *Client) Start() error {
-	err := s.Connect()
-	if err != nil {
-		return err
+	s.setState(StateConnecting)
+	if err := s.Connect(); err != nil {
+		s.setState(StateDisconnected)
+		if s.loginFailExit {
+			return err
+		}
+		log.Errorf("login failed: %s", err)
+		if err := s.reconnect(); err != nil {
+			return err
+		}
+	} else {
+		s.setState(StateConnected)
+		//主动发送消息
+		s.registerProxies()
 	}
-	//主动发送消息
-	context := s.Session.AllocateContext()
-	context.SetResponseMessage(&protocol.NewProxy{
-		ProxyName:  s.Conf.ProxyName,
-		RemotePort: s.Conf.RemotePort,
-		TraceId:    s.Session.id.(string),
-	})
-	s.Session.Send(context)
 	heartbeat := time.NewTicker(time.Duration(s.Conf.HeartbeatInterval) * time.Second)
 	defer heartbeat.Stop()
 	for {
+		session, negotiated := s.current()
 		select {
 		case <-heartbeat.C:
-			context.SetResponseMessage(&protocol.Ping{})
-			s.Session.Send(context)
-		case <-s.Session.closed:
-			for {
-				log.Info("try to reconnect....")
-				err := s.Connect()
-				if err != nil {
-					time.Sleep(time.Second * 5)
-					continue
-				}
-				//主动发送消息
-				context := s.Session.AllocateContext()
-				context.SetResponseMessage(&protocol.NewProxy{
-					ProxyName:  s.Conf.ProxyName,
-					RemotePort: s.Conf.RemotePort,
-					TraceId:    s.Session.id.(string),
-				})
-				s.Session.Send(context)
-				break
+			if !negotiated.HasFeature(protocol.FeatureHeartbeat) {
+				continue
+			}
+			session.SendCmd(&protocol.Ping{})
+		case <-session.closed:
+			s.setState(StateDisconnected)
+			s.resetProxyPhases()
+			if err := s.reconnect(); err != nil {
+				return err
 			}
 		case <-s.stopped:
 			return nil
@@ -203,36 +470,233 @@ func (s *Client) Start() error {
 
 	}
 }
-func (s *Client) CreateWorkerConn() (net.Conn, error) {
+
+// reconnect connects to the server until it succeeds, the attempts are delayed by
+// exponential backoff with jitter. All proxies are registered again after it.
+func (s *Client) reconnect() error {
+	b := newBackoff(s.reconnectInitial, s.reconnectMax)
+	for attempt := 1; ; attempt++ {
+		if s.reconnectMaxRetries > 0 && attempt > s.reconnectMaxRetries {
+			s.setState(StateGaveUp)
+			return fmt.Errorf("%w after %d attempts", ErrGaveUp, s.reconnectMaxRetries)
+		}
+		delay := b.Next()
+		log.Infof("try to reconnect in %s, attempt:[%d]....", delay, attempt)
+		select {
+		case <-time.After(delay):
+		case <-s.stopped:
+			return ErrClientStopped
+		}
+		s.setState(StateConnecting)
+		if err := s.Connect(); err != nil {
+			log.Errorf("reconnect failed: %s", err)
+			s.setState(StateDisconnected)
+			continue
+		}
+		s.setState(StateConnected)
+		//主动发送消息
+		s.registerProxies()
+		return nil
+	}
+}
+
+// registerProxies sends NewProxy for every configured proxy except the unhealthy ones.
+func (s *Client) registerProxies() {
+	s.proxyLock.Lock()
+	defer s.proxyLock.Unlock()
+	for _, pc := range s.proxies {
+		if s.unhealthy[pc.ProxyName] {
+			continue
+		}
+		s.sendNewProxy(pc)
+	}
+}
+
+// sendNewProxy registers the proxy, the result is set by SetProxyResult once it's responded.
+func (s *Client) sendNewProxy(pc *feature.ProxyConfig) {
+	session, negotiated := s.current()
+	if session.SendCmd(s.newProxyCmd(session, negotiated, pc)) {
+		s.phases[pc.ProxyName] = proxyPhase{phase: ProxyPhaseWaitStart}
+	}
+}
+
+// SetProxyHealth closes the proxy on the portal if the local service is down,
+// and registers it again when the service recovers.
+func (s *Client) SetProxyHealth(pc *feature.ProxyConfig, healthy bool) {
+	s.proxyLock.Lock()
+	defer s.proxyLock.Unlock()
+	// the checker of a reloaded proxy may report after it's replaced
+	if s.proxies[pc.ProxyName] != pc {
+		return
+	}
+	if s.unhealthy[pc.ProxyName] == !healthy {
+		return
+	}
+	if healthy {
+		delete(s.unhealthy, pc.ProxyName)
+	} else {
+		s.unhealthy[pc.ProxyName] = true
+	}
+	// not logged in yet, registerProxies takes care of it
+	session := s.Session()
+	if session == nil {
+		return
+	}
+	if healthy {
+		log.Infof("proxy:[%s] is healthy, register it", pc.ProxyName)
+		s.sendNewProxy(pc)
+		return
+	}
+	log.Warnf("proxy:[%s] is unhealthy, close it", pc.ProxyName)
+	session.SendCmd(&protocol.CloseProxy{ProxyName: pc.ProxyName})
+	s.phases[pc.ProxyName] = proxyPhase{phase: ProxyPhaseClosed}
+}
+
+func (s *Client) newProxyCmd(session *TcpSession, negotiated protocol.Capability, pc *feature.ProxyConfig) *protocol.NewProxy {
+	return &protocol.NewProxy{
+		ProxyName:              pc.ProxyName,
+		RemotePort:             pc.RemotePort,
+		TraceId:                session.ID().(string),
+		UseEncryption:          pc.UseEncryption,
+		UseCompression:         useCompression(negotiated, pc),
+		ProxyType:              pc.Type,
+		CustomDomains:          pc.CustomDomains,
+		SubDomain:              pc.SubDomain,
+		Locations:              pc.Locations,
+		Sk:                     pc.Sk,
+		Group:                  pc.Group,
+		GroupKey:               pc.GroupKey,
+		GroupStrategy:          pc.GroupStrategy,
+		PoolCount:              pc.PoolCount,
+		BandwidthLimit:         s.portalBandwidthLimit(pc),
+		MaxConnections:         pc.MaxConnections,
+		MaxConnectionsPerIP:    pc.MaxConnectionsPerIP,
+		MaxConnectionRatePerIP: pc.MaxConnectionRatePerIP,
+		AllowIPs:               pc.AllowIPs,
+		DenyIPs:                pc.DenyIPs,
+	}
+}
+
+// portalBandwidthLimit returns the bandwidth limit enforced by the portal, others are enforced by the bridge.
+func (s *Client) portalBandwidthLimit(pc *feature.ProxyConfig) int64 {
+	if pc.BandwidthLimitMode != protocol.BandwidthLimitModePortal {
+		return 0
+	}
+	return pc.BandwidthLimitBytes
+}
+
+// useCompression reports whether the work connections of the proxy are compressed,
+// compression is dropped if the portal doesn't support it.
+func useCompression(negotiated protocol.Capability, pc *feature.ProxyConfig) bool {
+	return pc.UseCompression && negotiated.HasCompression(protocol.CompressionFlate)
+}
+
+// CreateWorkerConn dials a work connection of the proxy and registers it to the portal,
+// it waits for StartWorkConn if the portal pools work connections. start is nil if the
+// portal doesn't send StartWorkConn.
+func (s *Client) CreateWorkerConn(pc *feature.ProxyConfig) (conn net.Conn, start *protocol.StartWorkConn, err error) {
 	//send worker
-	sessionId := s.Session.ID().(string)
+	session, negotiated := s.current()
+	if session == nil {
+		return nil, nil, ErrNotLoggedIn
+	}
+	sessionId := session.ID().(string)
+	timestamp, nonce := time.Now().Unix(), auth.NewNonce()
 	workCmd := &protocol.NewWorkCtl{
-		TraceID:   sessionId,
-		ProxyName: s.Conf.ProxyName,
+		TraceID:      sessionId,
+		ProxyName:    pc.ProxyName,
+		Timestamp:    timestamp,
+		Nonce:        nonce,
+		PrivilegeKey: auth.GetPrivilegeKey(s.Conf.AuthToken, timestamp, nonce),
 	}
 	log.Infof("send message:[workCtl],Session id:[%s]", sessionId)
-	log.Info("dial working server tcp:", s.Conf.ServerAddr)
-	workerConn, err := net.Dial("tcp", s.Conf.ServerAddr)
+	log.Info("dial working server tcp:", s.ServerAddr())
+	workerConn, err := s.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			workerConn.Close()
+		}
+	}()
+	workSession := NewTcpSession(&MasterConn{Conn: workerConn},
+		AsCodec(NewDefaultCodec()),
+		AsPacker(s.Packer),
+	)
+	err = workSession.SendCmdSync(workCmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	cmdSync, err := workSession.ReadCmdSync()
+	if err != nil {
+		return nil, nil, err
+	}
+	workCtlResp, ok := cmdSync.(*protocol.NewWorkCtlResp)
+	if !ok {
+		return nil, nil, errors.New("can't cast to NewWorkCtlResp")
+	}
+	if workCtlResp.Error != "" {
+		return nil, nil, errors.New(workCtlResp.Error)
+	}
+	if negotiated.HasFeature(protocol.FeatureStartWorkConn) {
+		cmd, err := protocol.ReadMsg(workerConn)
+		if err != nil {
+			return nil, nil, err
+		}
+		if start, ok = cmd.(*protocol.StartWorkConn); !ok {
+			return nil, nil, errors.New("can't cast to StartWorkConn")
+		}
+	}
+	token := s.Conf.AuthToken
+	if pc.Type == protocol.ProxyTypeSTCP {
+		// the payload is wrapped end to end with the visitor by sk instead of auth_token,
+		// the portal only relays it, though it knows sk since it verifies visitors with it
+		token = pc.Sk
+	}
+	conn, err = netio.WrapTunnelConn(workerConn, pc.UseEncryption, useCompression(negotiated, pc), token)
+	return conn, start, err
+}
+
+// CreateVisitorConn dials the portal and pairs the connection with a work connection
+// of the stcp proxy vc.ServerName.
+func (s *Client) CreateVisitorConn(vc *feature.VisitorConfig) (conn net.Conn, err error) {
+	visitorConn, err := s.dial()
 	if err != nil {
 		return nil, err
 	}
-	masterConn := &MasterConn{Conn: workerConn}
-	err = masterConn.SendCmdSync(workCmd)
+	defer func() {
+		if err != nil {
+			visitorConn.Close()
+		}
+	}()
+	visitorSession := NewTcpSession(&MasterConn{Conn: visitorConn},
+		AsCodec(NewDefaultCodec()),
+		AsPacker(s.Packer),
+	)
+	timestamp, nonce := time.Now().Unix(), auth.NewNonce()
+	err = visitorSession.SendCmdSync(&protocol.NewVisitorConn{
+		ProxyName: vc.ServerName,
+		SignKey:   auth.GetPrivilegeKey(vc.Sk, timestamp, nonce),
+		Timestamp: timestamp,
+		Nonce:     nonce,
+	})
 	if err != nil {
 		return nil, err
 	}
-	cmdSync, err := masterConn.ReadCmdSync()
+	cmdSync, err := visitorSession.ReadCmdSync()
 	if err != nil {
 		return nil, err
 	}
-	workCtlResp, ok := cmdSync.(*protocol.NewWorkCtlResp)
+	resp, ok := cmdSync.(*protocol.NewVisitorConnResp)
 	if !ok {
-		return nil, errors.New("can't cast to NewWorkCtlResp")
+		return nil, errors.New("can't cast to NewVisitorConnResp")
 	}
-	if workCtlResp.Error != "" {
-		return nil, errors.New(workCtlResp.Error)
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
 	}
-	return workerConn, nil
+	compression := vc.UseCompression && s.Negotiated().HasCompression(protocol.CompressionFlate)
+	return netio.WrapTunnelConn(visitorConn, vc.UseEncryption, compression, vc.Sk)
 }
 
 type ClientOption func(*Client)
@@ -243,12 +707,65 @@ func ClientConf(conf *feature.BridgeConfig) ClientOption {
 	}
 }
 
+// ClientTLSConfig enables tls on the master connection and every work connection.
+func ClientTLSConfig(tlsConfig *tls.Config) ClientOption {
+	return func(client *Client) {
+		client.tlsConfig = tlsConfig
+	}
+}
+
+// ClientMux multiplexes the master connection and all work connections over one physical connection.
+func ClientMux(enabled bool) ClientOption {
+	return func(client *Client) {
+		client.muxEnabled = enabled
+	}
+}
+
+// ClientReconnect sets the backoff of reconnecting, the delay starts from initial and
+// doubles until max. The client gives up after maxRetries attempts, 0 means forever.
+func ClientReconnect(initial, max time.Duration, maxRetries int) ClientOption {
+	return func(client *Client) {
+		client.reconnectInitial = initial
+		client.reconnectMax = max
+		client.reconnectMaxRetries = maxRetries
+	}
+}
+
+// ClientLoginFailExit makes Start return if the first login fails,
+// otherwise the client keeps reconnecting. By default, it's true.
+func ClientLoginFailExit(exit bool) ClientOption {
+	return func(client *Client) {
+		client.loginFailExit = exit
+	}
+}
+
+// ClientServers sets the servers to connect, they're tried one by one until the
+// login succeeds. By default, it's the ServerAddr of the config.
+func ClientServers(servers *ServerList) ClientOption {
+	return func(client *Client) {
+		client.servers = servers
+	}
+}
+
+// ClientTransport sets the transport to dial the server, by default it's tcp.
+func ClientTransport(t transport.Transport) ClientOption {
+	return func(client *Client) {
+		client.transport = t
+	}
+}
+
 func ClientPacker(pack Packer) ClientOption {
 	return func(client *Client) {
 		client.Packer = pack
 	}
 }
 
+func ClientCapability(capability protocol.Capability) ClientOption {
+	return func(client *Client) {
+		client.Capability = capability
+	}
+}
+
 func ClientCodec(codec Codec) ClientOption {
 	return func(client *Client) {
 		client.Codec = codec
@@ -265,6 +782,11 @@ func ClientOnSessionClose(fn func(sess Session)) ClientOption {
 		client.OnSessionClose = fn
 	}
 }
+func ClientOnStateChange(fn func(state ClientState)) ClientOption {
+	return func(client *Client) {
+		client.OnStateChange = fn
+	}
+}
 func ClientSocketReadBufferSize(readSize int) ClientOption {
 	return func(client *Client) {
 		client.socketReadBufferSize = readSize
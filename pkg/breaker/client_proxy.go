@@ -0,0 +1,174 @@
+package breaker
+
+import (
+	"breaker/feature"
+	"breaker/pkg/protocol"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// phases of proxies reported by ProxyStatuses
+const (
+	// ProxyPhaseNew is not registered since the bridge is not connected
+	ProxyPhaseNew = "new"
+	// ProxyPhaseWaitStart is registered and waiting for NewProxyResp
+	ProxyPhaseWaitStart = "wait_start"
+	ProxyPhaseRunning   = "running"
+	// ProxyPhaseStartError is rejected by the portal, see ProxyStatus.Error
+	ProxyPhaseStartError = "start_error"
+	// ProxyPhaseClosed is withdrawn since the local service is unhealthy
+	ProxyPhaseClosed = "closed"
+)
+
+// health states of ProxyStatus, it's empty if health check is disabled
+const (
+	ProxyHealthy   = "healthy"
+	ProxyUnhealthy = "unhealthy"
+)
+
+type proxyPhase struct {
+	phase string
+	err   string
+	// remotePort is responded by the portal, it's allocated if remote_port is 0
+	remotePort int
+}
+
+// ProxyStatus describes a configured proxy and its registration result.
+type ProxyStatus struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	LocalAddr  string `json:"local_addr,omitempty"`
+	Plugin     string `json:"plugin,omitempty"`
+	RemotePort int    `json:"remote_port,omitempty"`
+	Phase      string `json:"phase"`
+	// Error is NewProxyResp.Error of the last registration.
+	Error  string `json:"error,omitempty"`
+	Health string `json:"health,omitempty"`
+}
+
+// SessionID returns the id of the master session, it's empty before login.
+func (s *Client) SessionID() string {
+	session := s.Session()
+	if session == nil {
+		return ""
+	}
+	id, _ := session.ID().(string)
+	return id
+}
+
+// ProxyConf returns the config of the proxy, the config may be replaced by UpdateProxies.
+func (s *Client) ProxyConf(name string) (*feature.ProxyConfig, bool) {
+	s.proxyLock.Lock()
+	defer s.proxyLock.Unlock()
+	pc, ok := s.proxies[name]
+	return pc, ok
+}
+
+// SetProxyResult records the NewProxyResp of the proxy.
+func (s *Client) SetProxyResult(resp *protocol.NewProxyResp) {
+	s.proxyLock.Lock()
+	defer s.proxyLock.Unlock()
+	if _, ok := s.proxies[resp.ProxyName]; !ok {
+		return
+	}
+	if resp.Error != "" {
+		s.phases[resp.ProxyName] = proxyPhase{phase: ProxyPhaseStartError, err: resp.Error}
+		return
+	}
+	s.phases[resp.ProxyName] = proxyPhase{phase: ProxyPhaseRunning, remotePort: resp.RemotePort}
+}
+
+// resetProxyPhases forgets the registration results after the session is closed.
+func (s *Client) resetProxyPhases() {
+	s.proxyLock.Lock()
+	defer s.proxyLock.Unlock()
+	s.phases = make(map[string]proxyPhase)
+}
+
+// ProxyStatuses returns the status of every configured proxy sorted by name.
+func (s *Client) ProxyStatuses() []ProxyStatus {
+	s.proxyLock.Lock()
+	defer s.proxyLock.Unlock()
+	statuses := make([]ProxyStatus, 0, len(s.proxies))
+	for name, pc := range s.proxies {
+		status := ProxyStatus{
+			Name:       name,
+			Type:       pc.Type,
+			Plugin:     pc.Plugin,
+			RemotePort: pc.RemotePort,
+			Phase:      ProxyPhaseNew,
+		}
+		if pc.Plugin == "" {
+			status.LocalAddr = net.JoinHostPort(pc.LocalIP, strconv.Itoa(pc.LocalPort))
+		}
+		if phase, ok := s.phases[name]; ok {
+			status.Phase = phase.phase
+			status.Error = phase.err
+			if phase.remotePort != 0 {
+				status.RemotePort = phase.remotePort
+			}
+		}
+		if pc.HealthCheckType != "" {
+			status.Health = ProxyHealthy
+			if s.unhealthy[name] {
+				status.Health = ProxyUnhealthy
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// UpdateProxies replaces the configured proxies, the removed and changed proxies are
+// closed on the portal and the new and changed ones are registered, others keep running
+// with their old configs, so that their health checkers still match them.
+func (s *Client) UpdateProxies(proxies map[string]*feature.ProxyConfig) (added, removed, updated []string) {
+	s.proxyLock.Lock()
+	defer s.proxyLock.Unlock()
+	for name := range s.proxies {
+		if _, ok := proxies[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	next := make(map[string]*feature.ProxyConfig, len(proxies))
+	for name, pc := range proxies {
+		old, ok := s.proxies[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case reflect.DeepEqual(old, pc):
+			pc = old
+		default:
+			updated = append(updated, name)
+		}
+		next[name] = pc
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(updated)
+	session := s.Session()
+	closing := append(append([]string(nil), removed...), updated...)
+	for _, name := range closing {
+		delete(s.unhealthy, name)
+		delete(s.phases, name)
+		if session != nil {
+			log.Infof("proxy:[%s] is removed or changed, close it", name)
+			session.SendCmd(&protocol.CloseProxy{ProxyName: name})
+		}
+	}
+	s.proxies = next
+	for _, name := range append(append([]string(nil), added...), updated...) {
+		if session != nil {
+			log.Infof("proxy:[%s] is added or changed, register it", name)
+			s.sendNewProxy(next[name])
+		}
+	}
+	return added, removed, updated
+}
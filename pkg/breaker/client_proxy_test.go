@@ -0,0 +1,75 @@
+package breaker
+
+import (
+	"reflect"
+	"testing"
+
+	"breaker/feature"
+	"breaker/pkg/protocol"
+)
+
+func TestUpdateProxies(t *testing.T) {
+	kept := &feature.ProxyConfig{ProxyName: "kept", LocalPort: 80}
+	changed := &feature.ProxyConfig{ProxyName: "changed", LocalPort: 81}
+	removed := &feature.ProxyConfig{ProxyName: "removed", LocalPort: 82}
+	cli := NewClient(ClientConf(&feature.BridgeConfig{Proxies: map[string]*feature.ProxyConfig{
+		"kept": kept, "changed": changed, "removed": removed,
+	}}))
+
+	reloaded := map[string]*feature.ProxyConfig{
+		"kept":    {ProxyName: "kept", LocalPort: 80},
+		"changed": {ProxyName: "changed", LocalPort: 91},
+		"added":   {ProxyName: "added", LocalPort: 83},
+	}
+	added, gotRemoved, updated := cli.UpdateProxies(reloaded)
+	if !reflect.DeepEqual(added, []string{"added"}) ||
+		!reflect.DeepEqual(gotRemoved, []string{"removed"}) ||
+		!reflect.DeepEqual(updated, []string{"changed"}) {
+		t.Fatalf("added:%v removed:%v updated:%v", added, gotRemoved, updated)
+	}
+	if pc, _ := cli.ProxyConf("kept"); pc != kept {
+		t.Fatal("the unchanged proxy is replaced")
+	}
+	if pc, _ := cli.ProxyConf("changed"); pc != reloaded["changed"] {
+		t.Fatal("the changed proxy is not replaced")
+	}
+	if _, ok := cli.ProxyConf("removed"); ok {
+		t.Fatal("the removed proxy is still configured")
+	}
+
+	// the checker of the unchanged proxy keeps reporting with the old config
+	cli.SetProxyHealth(kept, false)
+	if !cli.unhealthy["kept"] {
+		t.Fatal("the health of the unchanged proxy is dropped")
+	}
+	// while the checker of the replaced config is stale
+	cli.SetProxyHealth(changed, false)
+	if cli.unhealthy["changed"] {
+		t.Fatal("the health of the replaced config is applied")
+	}
+}
+
+func TestSessionSwap(t *testing.T) {
+	cli := NewClient(ClientConf(&feature.BridgeConfig{}))
+	if cli.SessionID() != "" || cli.Session() != nil {
+		t.Fatal("session before login")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// reconnects replace the session while others read it
+		for i := 0; i < 100; i++ {
+			session := NewTcpSession(&MasterConn{})
+			session.SetID("session")
+			cli.setSession(session, protocol.Capability{Mux: i%2 == 0})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		cli.SessionID()
+		cli.Negotiated()
+	}
+	<-done
+	if cli.SessionID() != "session" {
+		t.Fatal("session id mismatch")
+	}
+}
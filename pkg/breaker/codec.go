@@ -1,7 +1,18 @@
 package breaker
 
+import (
+	"breaker/pkg/binpack"
+	"breaker/pkg/msgpack"
+	"breaker/pkg/protocol"
+	"encoding/json"
+	"fmt"
+)
+
 // Codec is a generic codec for encoding and decoding data.
 type Codec interface {
+	// Name returns the codec name, one of protocol.SupportedCodecs.
+	Name() string
+
 	// Encode encodes data into []byte.
 	// Returns error when error occurred.
 	Encode(v interface{}) ([]byte, error)
@@ -11,18 +22,73 @@ type Codec interface {
 	Decode(data []byte, v interface{}) error
 }
 
+// DefaultCodec encodes data as json, it's also the codec of the login handshake.
 type DefaultCodec struct{}
 
 func NewDefaultCodec() *DefaultCodec {
 	return &DefaultCodec{}
 }
 
+func (c *DefaultCodec) Name() string {
+	return protocol.CodecJson
+}
+
 func (c *DefaultCodec) Encode(v interface{}) ([]byte, error) {
-	//TODO implement me
-	panic("implement me")
+	return json.Marshal(v)
 }
 
 func (c *DefaultCodec) Decode(data []byte, v interface{}) error {
-	//TODO implement me
-	panic("implement me")
+	return json.Unmarshal(data, v)
+}
+
+// MsgpackCodec encodes data as MessagePack.
+type MsgpackCodec struct{}
+
+func NewMsgpackCodec() *MsgpackCodec {
+	return &MsgpackCodec{}
+}
+
+func (c *MsgpackCodec) Name() string {
+	return protocol.CodecMsgpack
+}
+
+func (c *MsgpackCodec) Encode(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (c *MsgpackCodec) Decode(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// BinaryCodec encodes data by struct field order without field names,
+// so the decoding side must use the same struct definition.
+type BinaryCodec struct{}
+
+func NewBinaryCodec() *BinaryCodec {
+	return &BinaryCodec{}
+}
+
+func (c *BinaryCodec) Name() string {
+	return protocol.CodecBinary
+}
+
+func (c *BinaryCodec) Encode(v interface{}) ([]byte, error) {
+	return binpack.Marshal(v)
+}
+
+func (c *BinaryCodec) Decode(data []byte, v interface{}) error {
+	return binpack.Unmarshal(data, v)
+}
+
+// CodecByName returns the codec registered with name.
+func CodecByName(name string) (Codec, error) {
+	switch name {
+	case "", protocol.CodecJson:
+		return NewDefaultCodec(), nil
+	case protocol.CodecMsgpack:
+		return NewMsgpackCodec(), nil
+	case protocol.CodecBinary:
+		return NewBinaryCodec(), nil
+	}
+	return nil, fmt.Errorf("unknown codec:[%s]", name)
 }
@@ -0,0 +1,99 @@
+package breaker
+
+import (
+	"breaker/pkg/protocol"
+	"reflect"
+	"testing"
+)
+
+func testCommands() []protocol.Command {
+	return []protocol.Command{
+		&protocol.NewMaster{
+			Capability:   protocol.LocalCapability(),
+			Timestamp:    1700000000,
+			PrivilegeKey: "key",
+		},
+		&protocol.NewProxy{
+			RemotePort:     6000,
+			ProxyName:      "ssh",
+			ProxyType:      protocol.ProxyTypeTCP,
+			UseEncryption:  true,
+			PoolCount:      5,
+			BandwidthLimit: 1 << 20,
+			AllowIPs:       []string{"10.0.0.0/8"},
+		},
+		&protocol.NewProxyResp{
+			Resp:       protocol.Resp{Error: "new Proxy error", Code: 1},
+			ProxyName:  "ssh",
+			RemotePort: 6000,
+		},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, name := range protocol.SupportedCodecs {
+		codec, err := CodecByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cmd := range testCommands() {
+			data, err := codec.Encode(cmd)
+			if err != nil {
+				t.Fatalf("%s encode %T: %s", name, cmd, err)
+			}
+			out := reflect.New(reflect.TypeOf(cmd).Elem()).Interface()
+			if err := codec.Decode(data, out); err != nil {
+				t.Fatalf("%s decode %T: %s", name, cmd, err)
+			}
+			if !reflect.DeepEqual(cmd, out) {
+				t.Fatalf("%s round trip mismatch:\n%+v\n%+v", name, cmd, out)
+			}
+		}
+	}
+}
+
+func TestCodecByNameUnknown(t *testing.T) {
+	if _, err := CodecByName("xml"); err == nil {
+		t.Fatal("unknown codec is accepted")
+	}
+}
+
+// codecSession only provides the codec used by Bind.
+type codecSession struct {
+	Session
+	codec Codec
+}
+
+func (s *codecSession) Codec() Codec {
+	return s.codec
+}
+
+func TestContextBind(t *testing.T) {
+	for _, name := range protocol.SupportedCodecs {
+		codec, _ := CodecByName(name)
+		want := testCommands()[1].(*protocol.NewProxy)
+		payload, err := codec.Encode(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := NewContext()
+		ctx.SetSession(&codecSession{codec: codec})
+		ctx.reqPayload = payload
+		got := &protocol.NewProxy{}
+		if err := ctx.Bind(got); err != nil {
+			t.Fatalf("%s bind: %s", name, err)
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("%s bind mismatch:\n%+v\n%+v", name, want, got)
+		}
+		// a request set by SetRequestMessage is encoded again
+		ctx.SetRequestMessage(want)
+		got = &protocol.NewProxy{}
+		if err := ctx.Bind(got); err != nil || !reflect.DeepEqual(want, got) {
+			t.Fatalf("%s bind request message: %v", name, err)
+		}
+	}
+	if err := NewContext().Bind(&protocol.NewProxy{}); err != ErrNoCodec {
+		t.Fatalf("got %v, want ErrNoCodec", err)
+	}
+}
@@ -28,7 +28,7 @@ func (m *MasterConn) LocalAddr() net.Addr {
 }
 
 func (m *MasterConn) RemoteAddr() net.Addr {
-	return m.Conn.LocalAddr()
+	return m.Conn.RemoteAddr()
 }
 
 func (m *MasterConn) SetDeadline(t time.Time) error {
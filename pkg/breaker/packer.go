@@ -5,13 +5,15 @@ import (
 	"io"
 )
 
+// Packer frames the encoded commands on the connection,
+// the payload of protocol.Packet is encoded and decoded by Codec.
 type Packer interface {
-	// Pack packs Message into the packet to be written.
-	Pack(cmd protocol.Command) ([]byte, error)
+	// Pack packs Packet into the bytes to be written.
+	Pack(p *protocol.Packet) ([]byte, error)
 
 	// Unpack unpacks the message packet from reader,
-	// returns the protocol.Command, and error if error occurred.
-	Unpack(reader io.Reader) (protocol.Command, error)
+	// returns the protocol.Packet, and error if error occurred.
+	Unpack(reader io.Reader) (*protocol.Packet, error)
 }
 
 type DefaultPacker struct{}
@@ -20,10 +22,10 @@ func NewDefaultPacker() *DefaultPacker {
 	return &DefaultPacker{}
 }
 
-func (p *DefaultPacker) Pack(cmd protocol.Command) ([]byte, error) {
-	return protocol.CmdToBytes(cmd)
+func (p *DefaultPacker) Pack(packet *protocol.Packet) ([]byte, error) {
+	return protocol.PacketToBytes(packet), nil
 }
 
-func (p *DefaultPacker) Unpack(reader io.Reader) (protocol.Command, error) {
-	return protocol.ReadMsg(reader)
+func (p *DefaultPacker) Unpack(reader io.Reader) (*protocol.Packet, error) {
+	return protocol.ReadPacket(reader)
 }
@@ -3,11 +3,17 @@ package breaker
 import (
 	"breaker/pkg/protocol"
 	"context"
+	"errors"
 	"net"
 	"sync"
 	"time"
 )
 
+var (
+	ErrNoCodec   = errors.New("session has no codec")
+	ErrNoRequest = errors.New("context has no request")
+)
+
 // Context is a generic context in a message routing.
 // It allows us to pass variables between handler and middlewares.
 type Context interface {
@@ -33,7 +39,8 @@ type Context interface {
 	// SetRedirectMessage sets redirect message entry directly.
 	// used for router
 	SetRedirectMessage(cmd protocol.Command) Context
-	// Bind decodes request message entry to v.
+	// Bind decodes the raw request payload to v with the Session's codec,
+	// so v can be any struct compatible with the request message.
 	Bind(v interface{}) error
 
 	// Response returns the response message entry.
@@ -74,6 +81,7 @@ type routeContext struct {
 	storage       sync.Map
 	session       Session
 	reqEntry      protocol.Command
+	reqPayload    []byte // raw request payload, nil when request is set by SetRequestMessage
 	respEntry     protocol.Command
 	redirectEntry protocol.Command
 }
@@ -127,12 +135,27 @@ func (r *routeContext) Request() protocol.Command {
 
 func (r *routeContext) SetRequestMessage(cmd protocol.Command) Context {
 	r.reqEntry = cmd
+	r.reqPayload = nil
 	return r
 }
 
 func (r *routeContext) Bind(v interface{}) error {
-	//TODO implement me
-	panic("implement me")
+	if r.session == nil || r.session.Codec() == nil {
+		return ErrNoCodec
+	}
+	codec := r.session.Codec()
+	payload := r.reqPayload
+	if payload == nil {
+		// the request is redirected or set manually, encode it to get the payload
+		if r.reqEntry == nil {
+			return ErrNoRequest
+		}
+		var err error
+		if payload, err = codec.Encode(r.reqEntry); err != nil {
+			return err
+		}
+	}
+	return codec.Decode(payload, v)
 }
 
 func (r *routeContext) Response() protocol.Command {
@@ -173,6 +196,8 @@ func (r *routeContext) reset() {
 	r.rawCtx = context.Background()
 	r.session = nil
 	r.reqEntry = nil
+	r.reqPayload = nil
 	r.respEntry = nil
+	r.redirectEntry = nil
 	r.storage = sync.Map{}
 }
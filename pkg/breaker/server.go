@@ -1,11 +1,16 @@
 package breaker
 
 import (
+	"breaker/pkg/mux"
+	"breaker/pkg/netio"
 	"breaker/pkg/protocol"
+	"breaker/pkg/transport"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -13,18 +18,25 @@ var ErrServerStopped = errors.New("breaker stopped")
 
 const (
 	tempErrDelay             = time.Millisecond * 5
+	handshakeTimeout         = time.Second * 10
+	tlsRecordHandshake       = 0x16
 	QueueSize                = 1024
 	DefaultWriteAttemptTimes = 1
 )
 
 type Server struct {
-	listener net.Listener
+	listeners    []net.Listener
+	listenerLock sync.Mutex
 	// Packer is the message packer, will be passed to Session.
 	Packer Packer
 
-	// Codec is the message codec, will be passed to Session.
+	// Codec is the message codec every Session starts with,
+	// it's expected to be replaced by the negotiated one after login.
 	Codec Codec
 
+	// Capability is what the server supports, used to negotiate with clients.
+	Capability protocol.Capability
+
 	// OnSessionCreate is an event hook, will be invoked when Session's created.
 	OnSessionCreate func(sess Session)
 
@@ -39,6 +51,10 @@ type Server struct {
 	router                *Router
 	stopped               chan struct{}
 	writeAttemptTimes     int
+	tlsConfig             *tls.Config
+	tlsOnly               bool
+	muxEnabled            bool
+	transport             transport.Transport
 }
 
 func NewServer(opts ...Option) *Server {
@@ -47,37 +63,44 @@ func NewServer(opts ...Option) *Server {
 		stopped:           make(chan struct{}),
 		Packer:            NewDefaultPacker(),
 		Codec:             NewDefaultCodec(),
+		Capability:        protocol.LocalCapability(),
 		router:            NewRouter(),
 		respQueueSize:     QueueSize,
 		writeAttemptTimes: DefaultWriteAttemptTimes,
+		transport:         transport.NewTCPTransport(),
 	}
 	for _, opt := range opts {
 		opt(srv)
 	}
+	srv.Capability.Mux = srv.muxEnabled
 	return srv
 }
 func (s *Server) Serve(addr string) error {
-	address, err := net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		return err
-	}
-	lis, err := net.ListenTCP("tcp", address)
+	return s.ServeTransport(s.transport, addr)
+}
+
+// ServeTransport accepts connections of t on addr, it can be called several
+// times so that bridges can connect by different transports.
+func (s *Server) ServeTransport(t transport.Transport, addr string) error {
+	lis, err := t.Listen(addr)
 	if err != nil {
 		return err
 	}
-	s.listener = lis
-	log.Infof("start tcp breaker:" + addr)
-	return s.acceptLoop()
+	s.listenerLock.Lock()
+	s.listeners = append(s.listeners, lis)
+	s.listenerLock.Unlock()
+	log.Infof("start %s breaker:%s", lis.Addr().Network(), addr)
+	return s.acceptLoop(lis)
 }
 
-func (s *Server) acceptLoop() error {
+func (s *Server) acceptLoop(lis net.Listener) error {
 	for {
 		if s.IsStopped() {
 			log.Tracef("breaker accept loop stopped")
 			return ErrServerStopped
 		}
 
-		conn, err := s.listener.Accept()
+		conn, err := lis.Accept()
 		if err != nil {
 			if s.IsStopped() {
 				log.Tracef("breaker accept loop stopped")
@@ -90,21 +113,33 @@ func (s *Server) acceptLoop() error {
 			}
 			return fmt.Errorf("accept err: %s", err)
 		}
-		if s.socketReadBufferSize > 0 {
-			if err := conn.(*net.TCPConn).SetReadBuffer(s.socketReadBufferSize); err != nil {
-				return fmt.Errorf("conn set read buffer err: %s", err)
-			}
-		}
-		if s.socketWriteBufferSize > 0 {
-			if err := conn.(*net.TCPConn).SetWriteBuffer(s.socketWriteBufferSize); err != nil {
-				return fmt.Errorf("conn set write buffer err: %s", err)
-			}
+		if err := setSocketBuffer(conn, s.socketReadBufferSize, s.socketWriteBufferSize); err != nil {
+			return err
 		}
 
 		go s.handleConn(conn)
 	}
 }
 
+// setSocketBuffer sets the buffer size of tcp connections, other connections are left unchanged.
+func setSocketBuffer(conn net.Conn, readSize, writeSize int) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if readSize > 0 {
+		if err := tcpConn.SetReadBuffer(readSize); err != nil {
+			return fmt.Errorf("conn set read buffer err: %s", err)
+		}
+	}
+	if writeSize > 0 {
+		if err := tcpConn.SetWriteBuffer(writeSize); err != nil {
+			return fmt.Errorf("conn set write buffer err: %s", err)
+		}
+	}
+	return nil
+}
+
 func (s *Server) IsStopped() bool {
 	select {
 	case <-s.stopped:
@@ -115,6 +150,28 @@ func (s *Server) IsStopped() bool {
 }
 
 func (s *Server) handleConn(conn net.Conn) {
+	conn, err := s.upgradeTLS(conn)
+	if err != nil {
+		log.Errorf("conn:[%s] %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	// multiplexed connections are served even if mux is disabled, so that the
+	// client is told mux is unsupported by the negotiated capability
+	var isMux bool
+	if conn, isMux, err = s.checkMux(conn); err != nil {
+		log.Errorf("conn:[%s] %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	if isMux {
+		s.serveMux(conn)
+		return
+	}
+	s.handleSession(conn)
+}
+
+func (s *Server) handleSession(conn net.Conn) {
 	c := &MasterConn{Conn: conn}
 	session := NewTcpSession(c,
 		AsCodec(s.Codec),
@@ -137,6 +194,79 @@ func (s *Server) handleConn(conn net.Conn) {
 		go s.OnSessionClose(session)
 	}
 }
+
+// serveMux handles every stream of the multiplexed connection as a standalone connection.
+func (s *Server) serveMux(conn net.Conn) {
+	muxSession := mux.Server(conn, nil)
+	log.Infof("new mux session from:[%s]", conn.RemoteAddr())
+	go func() {
+		select {
+		case <-muxSession.CloseChan():
+		case <-s.stopped:
+			muxSession.Close()
+		}
+	}()
+	for {
+		stream, err := muxSession.AcceptStream()
+		if err != nil {
+			log.Infof("mux session from:[%s] closed: %s", conn.RemoteAddr(), err)
+			return
+		}
+		go s.handleSession(stream)
+	}
+}
+
+// peekFirstByte returns the first byte sent by the client, the returned conn still reads it.
+func peekFirstByte(conn net.Conn) (net.Conn, byte, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return conn, 0, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	peekConn := netio.NewPeekConn(conn)
+	b, err := peekConn.Peek(1)
+	if err != nil {
+		return conn, 0, fmt.Errorf("peek first byte err: %s", err)
+	}
+	return peekConn, b[0], nil
+}
+
+// upgradeTLS wraps conn by tls if the client starts with a tls handshake,
+// plain connections are still accepted unless tlsOnly is set.
+func (s *Server) upgradeTLS(conn net.Conn) (net.Conn, error) {
+	if s.tlsConfig == nil {
+		return conn, nil
+	}
+	conn, first, err := peekFirstByte(conn)
+	if err != nil {
+		return conn, err
+	}
+	if first != tlsRecordHandshake {
+		if s.tlsOnly {
+			return conn, errors.New("plain connection is not allowed, tls only")
+		}
+		return conn, nil
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return conn, err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	tlsConn := tls.Server(conn, s.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return conn, fmt.Errorf("tls handshake err: %s", err)
+	}
+	return tlsConn, nil
+}
+
+// checkMux reports whether the client multiplexes streams over conn.
+// Commands never start with the mux version byte, so the first byte tells them apart.
+func (s *Server) checkMux(conn net.Conn) (net.Conn, bool, error) {
+	conn, first, err := peekFirstByte(conn)
+	if err != nil {
+		return conn, false, err
+	}
+	return conn, first == mux.Version, nil
+}
+
 func (s *Server) AddRoute(cmd protocol.Command, handler HandlerFunc, middlewares ...MiddlewareFunc) {
 	s.router.register(cmd, handler, middlewares...)
 }
@@ -154,11 +284,41 @@ func (s *Server) NotFoundHandler(handler HandlerFunc) {
 // Stop stops breaker. Closing Listener and all connections.
 func (s *Server) Stop() error {
 	close(s.stopped)
-	return s.listener.Close()
+	s.listenerLock.Lock()
+	defer s.listenerLock.Unlock()
+	var err error
+	for _, lis := range s.listeners {
+		if e := lis.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
 }
 
 type Option func(*Server)
 
+// WithTLSConfig accepts tls connections, plain connections are still accepted unless tlsOnly is set.
+func WithTLSConfig(tlsConfig *tls.Config, tlsOnly bool) Option {
+	return func(server *Server) {
+		server.tlsConfig = tlsConfig
+		server.tlsOnly = tlsOnly
+	}
+}
+
+// WithMux negotiates multiplexing by pkg/mux with clients, each stream is served as a connection.
+func WithMux(enabled bool) Option {
+	return func(server *Server) {
+		server.muxEnabled = enabled
+	}
+}
+
+// WithTransport sets the transport used by Serve, by default it's tcp.
+func WithTransport(t transport.Transport) Option {
+	return func(server *Server) {
+		server.transport = t
+	}
+}
+
 func WithPacker(pack Packer) Option {
 	return func(server *Server) {
 		server.Packer = pack
@@ -171,6 +331,12 @@ func WithCodec(codec Codec) Option {
 	}
 }
 
+func WithCapability(capability protocol.Capability) Option {
+	return func(server *Server) {
+		server.Capability = capability
+	}
+}
+
 func WithOnSessionCreate(fn func(sess Session)) Option {
 	return func(server *Server) {
 		server.OnSessionCreate = fn
@@ -0,0 +1,65 @@
+package breaker
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerList holds the addresses of the servers a client can connect to,
+// the addresses of a DNS SRV record are looked up every time.
+type ServerList struct {
+	Addrs []string
+	// SRVName is the full name of the SRV record, e.g. _breaker._tcp.example.com.
+	SRVName string
+	// Random tries the servers in random order instead of the configured order.
+	Random bool
+
+	rand     *rand.Rand
+	randLock sync.Mutex
+}
+
+func NewServerList(addrs []string, srvName string, random bool) *ServerList {
+	return &ServerList{
+		Addrs:   addrs,
+		SRVName: srvName,
+		Random:  random,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Candidates returns the addresses in the order they should be tried,
+// the targets of the SRV record go first in the order of their priorities.
+func (l *ServerList) Candidates() ([]string, error) {
+	var addrs []string
+	var lookupErr error
+	if l.SRVName != "" {
+		_, records, err := net.LookupSRV("", "", l.SRVName)
+		if err != nil {
+			lookupErr = err
+		}
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+		}
+	}
+	addrs = append(addrs, l.Addrs...)
+	if len(addrs) == 0 {
+		if lookupErr != nil {
+			return nil, lookupErr
+		}
+		return nil, errors.New("no server address")
+	}
+	if l.Random {
+		l.randLock.Lock()
+		l.rand.Shuffle(len(addrs), func(i, j int) {
+			addrs[i], addrs[j] = addrs[j], addrs[i]
+		})
+		l.randLock.Unlock()
+	}
+	return addrs, nil
+}
@@ -0,0 +1,100 @@
+package breaker
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"breaker/feature"
+)
+
+func TestCandidatesOrder(t *testing.T) {
+	addrs := []string{"a:1", "b:2", "c:3"}
+	l := NewServerList(addrs, "", false)
+	for i := 0; i < 3; i++ {
+		got, err := l.Candidates()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, addrs) {
+			t.Fatalf("got %v, want %v", got, addrs)
+		}
+	}
+}
+
+func TestCandidatesRandom(t *testing.T) {
+	addrs := []string{"a:1", "b:2", "c:3", "d:4"}
+	l := NewServerList(addrs, "", true)
+	l.rand = rand.New(rand.NewSource(1))
+	shuffled := false
+	for i := 0; i < 10; i++ {
+		got, err := l.Candidates()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, addrs) {
+			shuffled = true
+		}
+		sorted := append([]string(nil), got...)
+		sort.Strings(sorted)
+		if !reflect.DeepEqual(sorted, addrs) {
+			t.Fatalf("%v isn't a permutation of %v", got, addrs)
+		}
+	}
+	if !shuffled {
+		t.Fatal("candidates are never shuffled")
+	}
+	// the configured addresses are untouched
+	if !reflect.DeepEqual(l.Addrs, []string{"a:1", "b:2", "c:3", "d:4"}) {
+		t.Fatalf("configured addresses are changed: %v", l.Addrs)
+	}
+}
+
+func TestCandidatesEmpty(t *testing.T) {
+	if _, err := NewServerList(nil, "", false).Candidates(); err == nil {
+		t.Fatal("no error without any address")
+	}
+}
+
+// refusedTransport refuses every dial and records the addresses.
+type refusedTransport struct {
+	dialed []string
+}
+
+func (t *refusedTransport) Dial(addr string) (net.Conn, error) {
+	t.dialed = append(t.dialed, addr)
+	return nil, errors.New("refused: " + addr)
+}
+
+func (t *refusedTransport) Listen(addr string) (net.Listener, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestConnectFailover(t *testing.T) {
+	tr := &refusedTransport{}
+	cli := NewClient(
+		ClientConf(&feature.BridgeConfig{}),
+		ClientServers(NewServerList([]string{"a:1", "b:2", "c:3"}, "", false)),
+		ClientTransport(tr),
+	)
+	err := cli.Connect()
+	if err == nil || err.Error() != "refused: c:3" {
+		t.Fatalf("got %v, want the error of the last server", err)
+	}
+	if !reflect.DeepEqual(tr.dialed, []string{"a:1", "b:2", "c:3"}) {
+		t.Fatalf("dialed %v", tr.dialed)
+	}
+	if cli.ServerAddr() != "c:3" {
+		t.Fatalf("server in use: %s", cli.ServerAddr())
+	}
+
+	// every reconnection starts over from the first server
+	tr.dialed = nil
+	cli.Connect()
+	if !reflect.DeepEqual(tr.dialed, []string{"a:1", "b:2", "c:3"}) {
+		t.Fatalf("dialed %v on reconnection", tr.dialed)
+	}
+}
@@ -22,6 +22,15 @@ type Session interface {
 	// Codec returns the codec, can be nil.
 	Codec() Codec
 
+	// SetCodec replaces the codec, e.g. after the codec is negotiated during login.
+	SetCodec(codec Codec)
+
+	// Capability returns the capability negotiated during the login of the Session.
+	Capability() protocol.Capability
+
+	// SetCapability records the negotiated capability.
+	SetCapability(capability protocol.Capability)
+
 	// Close closes current Session.
 	Close()
 
@@ -31,6 +40,9 @@ type Session interface {
 	Conn() net.Conn
 
 	SendSync(context Context) bool
+
+	// QueueLen returns the number of responses waiting in the respQueue.
+	QueueLen() int
 }
 
 type TcpSession struct {
@@ -42,7 +54,11 @@ type TcpSession struct {
 	respQueue chan Context  // response queue channel, pushed in Send() and popped in writeOutbound()
 	packer    Packer        // to pack and unpack message
 	codec     Codec         // encode/decode message data
+	codecLock sync.RWMutex  // codec and capability may be replaced after login
 	ctxPool   sync.Pool     // router context pool
+
+	// capability is negotiated during login, it's empty for work connections
+	capability protocol.Capability
 }
 
 func (s *TcpSession) Conn() net.Conn {
@@ -57,6 +73,8 @@ func NewTcpSession(conn *MasterConn, ops ...SessionOpt) *TcpSession {
 		conn:      conn,
 		closed:    make(chan struct{}),
 		respQueue: make(chan Context, QueueSize),
+		packer:    NewDefaultPacker(),
+		codec:     NewDefaultCodec(),
 		ctxPool:   sync.Pool{New: func() interface{} { return NewContext() }},
 	}
 	for _, op := range ops {
@@ -65,6 +83,10 @@ func NewTcpSession(conn *MasterConn, ops ...SessionOpt) *TcpSession {
 	return session
 }
 
+func (s *TcpSession) QueueLen() int {
+	return len(s.respQueue)
+}
+
 func (s *TcpSession) ID() interface{} {
 	return s.id
 }
@@ -95,12 +117,13 @@ func (s *TcpSession) SendSync(ctx Context) bool {
 		return false
 	default:
 	}
-	outboundMsg, err := s.packResponse(ctx)
-	if err != nil {
-		log.Errorf("Session %s pack outbound message err: %s", s.id, err)
+	// ctx is still owned by the handler, it's recycled after the handler returns
+	if ctx.Response() == nil {
 		return false
 	}
-	if outboundMsg == nil {
+	outboundMsg, err := s.packCmd(ctx.Response())
+	if err != nil {
+		log.Errorf("Session %s pack outbound message err: %s", s.id, err)
 		return false
 	}
 	if err := s.attemptConnWrite(outboundMsg, 1); err != nil {
@@ -111,9 +134,29 @@ func (s *TcpSession) SendSync(ctx Context) bool {
 }
 
 func (s *TcpSession) Codec() Codec {
+	s.codecLock.RLock()
+	defer s.codecLock.RUnlock()
 	return s.codec
 }
 
+func (s *TcpSession) SetCodec(codec Codec) {
+	s.codecLock.Lock()
+	defer s.codecLock.Unlock()
+	s.codec = codec
+}
+
+func (s *TcpSession) Capability() protocol.Capability {
+	s.codecLock.RLock()
+	defer s.codecLock.RUnlock()
+	return s.capability
+}
+
+func (s *TcpSession) SetCapability(capability protocol.Capability) {
+	s.codecLock.Lock()
+	defer s.codecLock.Unlock()
+	s.capability = capability
+}
+
 func (s *TcpSession) Close() {
 	s.closeOnce.Do(func() {
 		close(s.closed)
@@ -121,6 +164,10 @@ func (s *TcpSession) Close() {
 }
 
 func (s *TcpSession) AllocateContext() Context {
+	return s.allocateContext()
+}
+
+func (s *TcpSession) allocateContext() *routeContext {
 	c := s.ctxPool.Get().(*routeContext)
 	c.reset()
 	c.SetSession(s)
@@ -140,18 +187,25 @@ func (s *TcpSession) readInbound(router *Router, timeout time.Duration) {
 				break
 			}
 		}
-		reqEntry, err := s.packer.Unpack(s.conn)
+		packet, err := s.packer.Unpack(s.conn)
 		if err != nil {
 			log.Errorf("Session %s unpack inbound packet err: %s", s.id, err)
 			break
 		}
-		if reqEntry == nil {
+		if packet == nil {
+			continue
+		}
+		reqEntry, err := s.decodeCmd(packet)
+		if err != nil {
+			log.Errorf("Session %s decode inbound packet err: %s", s.id, err)
 			continue
 		}
 
-		s.handleReq(router, reqEntry)
+		s.handleReq(router, reqEntry, packet.Payload)
 	}
 	log.Tracef("Session %s readInbound exit because of error", s.id)
+	// nothing can be read from the conn any more, release it
+	s.conn.Close()
 	s.Close()
 }
 
@@ -190,8 +244,10 @@ func (s *TcpSession) writeOutbound(timeout time.Duration, times int) {
 	log.Tracef("Session %s writeOutbound exit because of error", s.id)
 }
 
-func (s *TcpSession) handleReq(router *Router, entry protocol.Command) {
-	ctx := s.AllocateContext().SetRequestMessage(entry)
+func (s *TcpSession) handleReq(router *Router, entry protocol.Command, payload []byte) {
+	ctx := s.allocateContext()
+	ctx.SetRequestMessage(entry)
+	ctx.reqPayload = payload
 	router.handleRequest(ctx)
 	for ctx.Redirect() != nil {
 		ctx.SetRequestMessage(ctx.Redirect())
@@ -233,15 +289,48 @@ func (s *TcpSession) packResponse(ctx Context) ([]byte, error) {
 	if ctx.Response() == nil {
 		return nil, nil
 	}
-	return s.packer.Pack(ctx.Response())
+	return s.packCmd(ctx.Response())
+}
+
+// packCmd encodes cmd with the Session's codec and frames it with the packer.
+func (s *TcpSession) packCmd(cmd protocol.Command) ([]byte, error) {
+	payload, err := s.Codec().Encode(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return s.packer.Pack(&protocol.Packet{Type: cmd.Type(), Payload: payload})
 }
 
+func (s *TcpSession) decodeCmd(packet *protocol.Packet) (protocol.Command, error) {
+	cmd, err := protocol.NewCommand(packet.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Codec().Decode(packet.Payload, cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// SendCmdSync writes cmd to the connection directly, bypassing the respQueue.
+// It should only be used before the Session starts its read/write loops, e.g. during login.
 func (s *TcpSession) SendCmdSync(cmd protocol.Command) error {
-	return s.conn.SendCmdSync(cmd)
+	outboundMsg, err := s.packCmd(cmd)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.Write(outboundMsg)
+	return err
 }
 
+// ReadCmdSync reads the next command from the connection directly.
+// It should only be used before the Session starts its read loop, e.g. during login.
 func (s *TcpSession) ReadCmdSync() (protocol.Command, error) {
-	return s.conn.ReadCmdSync()
+	packet, err := s.packer.Unpack(s.conn)
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeCmd(packet)
 }
 
 func AsPacker(packer Packer) SessionOpt {
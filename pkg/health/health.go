@@ -0,0 +1,118 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// types of health checks
+const (
+	CheckTypeTCP  = "tcp"
+	CheckTypeHTTP = "http"
+)
+
+// Checker checks the local service periodically, OnDown is called once the
+// failures reach MaxFailed, and OnUp is called when it recovers.
+type Checker struct {
+	Type string
+	// Addr is the address of the local service.
+	Addr string
+	// Path is requested by http checks, 2xx responses are healthy.
+	Path      string
+	Interval  time.Duration
+	Timeout   time.Duration
+	MaxFailed int
+	OnDown    func()
+	OnUp      func()
+
+	httpClient *http.Client
+	stopped    chan struct{}
+	stopOnce   sync.Once
+}
+
+func NewChecker(checkType, addr, path string, interval, timeout time.Duration, maxFailed int) *Checker {
+	return &Checker{
+		Type:      checkType,
+		Addr:      addr,
+		Path:      path,
+		Interval:  interval,
+		Timeout:   timeout,
+		MaxFailed: maxFailed,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+		stopped: make(chan struct{}),
+	}
+}
+
+func (c *Checker) Start() {
+	go c.loop()
+}
+
+func (c *Checker) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopped)
+	})
+}
+
+func (c *Checker) loop() {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	healthy := true
+	failed := 0
+	for {
+		err := c.check()
+		if err == nil {
+			failed = 0
+			if !healthy {
+				healthy = true
+				log.Infof("health check of:[%s] recovered", c.Addr)
+				if c.OnUp != nil {
+					c.OnUp()
+				}
+			}
+		} else {
+			failed++
+			log.Warnf("health check of:[%s] failed %d times: %s", c.Addr, failed, err)
+			if healthy && failed >= c.MaxFailed {
+				healthy = false
+				if c.OnDown != nil {
+					c.OnDown()
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-c.stopped:
+			return
+		}
+	}
+}
+
+func (c *Checker) check() error {
+	switch c.Type {
+	case CheckTypeTCP:
+		conn, err := net.DialTimeout("tcp", c.Addr, c.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case CheckTypeHTTP:
+		resp, err := c.httpClient.Get("http://" + c.Addr + c.Path)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("unexpected status code:%d", resp.StatusCode)
+		}
+		return nil
+	default:
+		return errors.New("unknown health check type:" + c.Type)
+	}
+}
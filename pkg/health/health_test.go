@@ -0,0 +1,119 @@
+package health
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testInterval = 20 * time.Millisecond
+
+// waitEvent returns the next event, or fails the test if there is none in time.
+func waitEvent(t *testing.T, events chan string) string {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("no health event")
+		return ""
+	}
+}
+
+func expectNoEvent(t *testing.T, events chan string, d time.Duration) {
+	t.Helper()
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected health event:%s", e)
+	case <-time.After(d):
+	}
+}
+
+func TestTCPChecker(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	events := make(chan string, 10)
+	c := NewChecker(CheckTypeTCP, addr, "", testInterval, testInterval, 2)
+	c.OnDown = func() { events <- "down" }
+	c.OnUp = func() { events <- "up" }
+	c.Start()
+	defer c.Stop()
+	// a healthy service reports nothing
+	expectNoEvent(t, events, 5*testInterval)
+
+	l.Close()
+	if e := waitEvent(t, events); e != "down" {
+		t.Fatalf("got %s, want down", e)
+	}
+	// OnDown is called once however long the service is down
+	expectNoEvent(t, events, 5*testInterval)
+
+	if l, err = net.Listen("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if e := waitEvent(t, events); e != "up" {
+		t.Fatalf("got %s, want up", e)
+	}
+	expectNoEvent(t, events, 5*testInterval)
+}
+
+func TestHTTPCheckerThreshold(t *testing.T) {
+	var failing int32
+	var failures int64
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/health" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.LoadInt32(&failing) == 1 {
+			atomic.AddInt64(&failures, 1)
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	events := make(chan string, 10)
+	c := NewChecker(CheckTypeHTTP, strings.TrimPrefix(srv.URL, "http://"), "/health",
+		testInterval, time.Second, 3)
+	c.OnDown = func() {
+		// the failures at the moment it's reported down
+		events <- "down:" + strconv.FormatInt(atomic.LoadInt64(&failures), 10)
+	}
+	c.OnUp = func() { events <- "up" }
+	c.Start()
+	expectNoEvent(t, events, 5*testInterval)
+
+	atomic.StoreInt32(&failing, 1)
+	if e := waitEvent(t, events); e != "down:3" {
+		t.Fatalf("got %s, want down:3", e)
+	}
+	atomic.StoreInt32(&failing, 0)
+	// a single success recovers it
+	if e := waitEvent(t, events); e != "up" {
+		t.Fatalf("got %s, want up", e)
+	}
+
+	// nothing is reported after it's stopped
+	c.Stop()
+	time.Sleep(2 * testInterval)
+	atomic.StoreInt32(&failing, 1)
+	expectNoEvent(t, events, 10*testInterval)
+}
+
+func TestCheckUnknownType(t *testing.T) {
+	c := NewChecker("udp", "127.0.0.1:1", "", testInterval, testInterval, 1)
+	if err := c.check(); err == nil {
+		t.Fatal("unknown type is checked")
+	}
+}
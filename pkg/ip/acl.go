@@ -0,0 +1,95 @@
+package ip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ACL checks source ips by CIDR lists, denied ips are rejected even if they're
+// allowed. Every ip is allowed if the allow list is empty.
+type ACL struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewACL parses the CIDRs of the lists, a single ip is the same as a CIDR of itself.
+func NewACL(allow, deny []string) (*ACL, error) {
+	allowNets, err := parseCIDRs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := parseCIDRs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &ACL{
+		allow: allowNets,
+		deny:  denyNets,
+	}, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip:%s", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR:%s", cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Allowed reports whether the ip passes the lists, a nil ACL allows every ip.
+func (a *ACL) Allowed(ip net.IP) bool {
+	if a == nil {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range a.deny {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, ipNet := range a.allow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedAddr is Allowed with the ip of a network address like "1.2.3.4:5678".
+func (a *ACL) AllowedAddr(addr net.Addr) bool {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return a.Allowed(net.ParseIP(host))
+}
+
+// Empty reports whether the ACL allows every ip.
+func (a *ACL) Empty() bool {
+	return a == nil || (len(a.allow) == 0 && len(a.deny) == 0)
+}
@@ -0,0 +1,83 @@
+package ip
+
+import (
+	"net"
+	"testing"
+)
+
+func TestACLAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow, deny []string
+		ip          string
+		want        bool
+	}{
+		{"empty lists", nil, nil, "1.2.3.4", true},
+		{"blank entries", []string{" ", ""}, nil, "1.2.3.4", true},
+		{"allowed by CIDR", []string{"10.0.0.0/8"}, nil, "10.1.2.3", true},
+		{"not in allow list", []string{"10.0.0.0/8"}, nil, "11.0.0.1", false},
+		{"allowed by single ip", []string{"192.168.1.1"}, nil, "192.168.1.1", true},
+		{"single ip is /32", []string{"192.168.1.1"}, nil, "192.168.1.2", false},
+		{"denied by CIDR", nil, []string{"10.0.0.0/8"}, "10.1.2.3", false},
+		{"not in deny list", nil, []string{"10.0.0.0/8"}, "11.0.0.1", true},
+		{"deny wins over allow", []string{"10.0.0.0/8"}, []string{"10.0.0.1"}, "10.0.0.1", false},
+		{"allowed beside denied", []string{"10.0.0.0/8"}, []string{"10.0.0.1"}, "10.0.0.2", true},
+		{"ipv6 CIDR", []string{"2001:db8::/32"}, nil, "2001:db8::1", true},
+		{"ipv6 outside CIDR", []string{"2001:db8::/32"}, nil, "2001:db9::1", false},
+		{"ipv6 single ip", nil, []string{"::1"}, "::1", false},
+		{"ipv4 list with ipv6 ip", []string{"10.0.0.0/8"}, nil, "::1", false},
+		{"invalid ip", nil, nil, "not an ip", false},
+	}
+	for _, tt := range tests {
+		acl, err := NewACL(tt.allow, tt.deny)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if got := acl.Allowed(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("%s: Allowed(%s) = %v, want %v", tt.name, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestACLAllowedAddr(t *testing.T) {
+	acl, err := NewACL([]string{"127.0.0.1", "::1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acl.AllowedAddr(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5678}) {
+		t.Error("ipv4 address is denied")
+	}
+	if !acl.AllowedAddr(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 5678}) {
+		t.Error("ipv6 address is denied")
+	}
+	if acl.AllowedAddr(&net.TCPAddr{IP: net.ParseIP("127.0.0.2"), Port: 5678}) {
+		t.Error("address outside the allow list is allowed")
+	}
+}
+
+func TestNewACLInvalid(t *testing.T) {
+	for _, entry := range []string{"1.2.3", "10.0.0.0/33", "example.com", "::1/129"} {
+		if _, err := NewACL([]string{entry}, nil); err == nil {
+			t.Errorf("allow entry %q is accepted", entry)
+		}
+		if _, err := NewACL(nil, []string{entry}); err == nil {
+			t.Errorf("deny entry %q is accepted", entry)
+		}
+	}
+}
+
+func TestACLNilAndEmpty(t *testing.T) {
+	var acl *ACL
+	if !acl.Allowed(net.ParseIP("1.2.3.4")) || !acl.Empty() {
+		t.Error("nil ACL doesn't allow every ip")
+	}
+	acl, _ = NewACL(nil, nil)
+	if !acl.Empty() {
+		t.Error("ACL without entries isn't empty")
+	}
+	acl, _ = NewACL(nil, []string{"10.0.0.1"})
+	if acl.Empty() {
+		t.Error("ACL with a deny entry is empty")
+	}
+}
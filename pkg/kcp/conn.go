@@ -0,0 +1,517 @@
+package kcp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// keepAliveInterval is the interval of window probes sent to keep the conn alive
+	keepAliveInterval = 10 * time.Second
+	// idleTimeout closes the conn if nothing is received from the peer
+	idleTimeout = 30 * time.Second
+	// lingerTimeout is the max time waiting for the peer to finish after Close
+	lingerTimeout = 10 * time.Second
+	// maxPacketSize is the buffer size of reading udp packets
+	maxPacketSize = 64 * 1024
+)
+
+var (
+	ErrClosed      = errors.New("kcp: use of closed connection")
+	ErrDeadLink    = errors.New("kcp: dead link, too many retransmissions")
+	ErrIdleTimeout = errors.New("kcp: idle timeout")
+	ErrTimeout     = timeoutError{}
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "kcp: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type Config struct {
+	// NoDelay enables the fast mode, rto starts lower and grows slower.
+	NoDelay bool
+	// Interval is the internal update interval in milliseconds.
+	Interval int
+	// Resend triggers fast retransmission after the segment is skipped by this many acks, 0 disables it.
+	Resend int
+	// NoCongestion disables the congestion control.
+	NoCongestion bool
+	// SndWnd and RcvWnd are the max send and receive window in packets.
+	SndWnd int
+	RcvWnd int
+	// MTU is the max size of udp packets.
+	MTU int
+	// MaxConns is the max conns of a Listener, packets starting new streams are
+	// dropped over it, so that spoofed packets can't allocate unbounded conns.
+	// It's not used by Dial, the default is used if it's 0.
+	MaxConns int
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		NoDelay:      true,
+		Interval:     20,
+		Resend:       2,
+		NoCongestion: true,
+		SndWnd:       128,
+		RcvWnd:       512,
+		MTU:          1350,
+		MaxConns:     1024,
+	}
+}
+
+// Conn is a reliable stream over udp, it implements net.Conn.
+type Conn struct {
+	conv   uint32
+	kcp    *KCP
+	config *Config
+	local  net.Addr
+	remote net.Addr
+	// release frees the resources of the conn once it's finished
+	release func()
+
+	lock          sync.Mutex
+	closed        bool
+	closeTime     time.Time
+	lastRecv      time.Time
+	lastProbe     time.Time
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readNotify  chan struct{}
+	writeNotify chan struct{}
+
+	die     chan struct{}
+	dieOnce sync.Once
+	dieErr  error
+}
+
+func newConn(conv uint32, config *Config, local, remote net.Addr, output func([]byte), release func()) *Conn {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	c := &Conn{
+		conv:        conv,
+		config:      config,
+		local:       local,
+		remote:      remote,
+		release:     release,
+		lastRecv:    time.Now(),
+		lastProbe:   time.Now(),
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+		die:         make(chan struct{}),
+	}
+	c.kcp = NewKCP(conv, output)
+	c.kcp.SetNoDelay(config.NoDelay, config.Interval, config.Resend, config.NoCongestion)
+	c.kcp.SetWndSize(config.SndWnd, config.RcvWnd)
+	c.kcp.SetMtu(config.MTU)
+	c.kcp.Update()
+	go c.updateLoop()
+	return c
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Conn) updateLoop() {
+	ticker := time.NewTicker(time.Duration(c.kcp.interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.die:
+			return
+		}
+		c.lock.Lock()
+		now := time.Now()
+		if now.Sub(c.lastProbe) >= keepAliveInterval {
+			c.lastProbe = now
+			c.kcp.Probe()
+		}
+		c.kcp.Update()
+		var err error
+		switch {
+		case c.kcp.IsDead():
+			err = ErrDeadLink
+		case now.Sub(c.lastRecv) >= idleTimeout:
+			err = ErrIdleTimeout
+		case c.closed && (c.kcp.Finished() || now.Sub(c.closeTime) >= lingerTimeout):
+			err = ErrClosed
+		}
+		writable := c.kcp.WaitSnd() < int(c.kcp.sndWnd)
+		c.lock.Unlock()
+		if err != nil {
+			c.destroy(err)
+			return
+		}
+		if writable {
+			notify(c.writeNotify)
+		}
+	}
+}
+
+// input feeds a packet received from the peer.
+func (c *Conn) input(data []byte) {
+	c.lock.Lock()
+	if c.kcp.Input(data) < 0 {
+		c.lock.Unlock()
+		return
+	}
+	c.lastRecv = time.Now()
+	if c.closed {
+		c.kcp.discardRecv()
+	}
+	// acknowledge at once, it makes rtt estimation accurate
+	c.kcp.Flush()
+	c.lock.Unlock()
+	notify(c.readNotify)
+	notify(c.writeNotify)
+}
+
+// destroy releases the conn without waiting for the peer.
+func (c *Conn) destroy(err error) {
+	c.dieOnce.Do(func() {
+		c.dieErr = err
+		close(c.die)
+		if c.release != nil {
+			c.release()
+		}
+	})
+}
+
+func (c *Conn) isDead() bool {
+	select {
+	case <-c.die:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for {
+		c.lock.Lock()
+		if n := c.kcp.Recv(b); n > 0 {
+			c.lock.Unlock()
+			return n, nil
+		}
+		if c.kcp.Eof() {
+			c.lock.Unlock()
+			return 0, io.EOF
+		}
+		if c.closed {
+			c.lock.Unlock()
+			return 0, ErrClosed
+		}
+		deadline := c.readDeadline
+		c.lock.Unlock()
+		if c.isDead() {
+			return 0, c.dieErr
+		}
+		if err := c.wait(c.readNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	for {
+		c.lock.Lock()
+		if c.closed {
+			c.lock.Unlock()
+			return 0, ErrClosed
+		}
+		if c.isDead() {
+			c.lock.Unlock()
+			return 0, c.dieErr
+		}
+		if c.kcp.WaitSnd() < int(c.kcp.sndWnd) {
+			c.kcp.Send(b)
+			c.kcp.Flush()
+			c.lock.Unlock()
+			return len(b), nil
+		}
+		deadline := c.writeDeadline
+		c.lock.Unlock()
+		if err := c.wait(c.writeNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// wait blocks until ch is notified, the deadline passes or the conn is destroyed.
+func (c *Conn) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return ErrTimeout
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return ErrTimeout
+	case <-c.die:
+		// read once more, data received before may still be buffered
+		return nil
+	}
+}
+
+// Close sends FIN to the peer, the conn lingers until the peer finishes too.
+func (c *Conn) Close() error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.closeTime = time.Now()
+	c.kcp.discardRecv()
+	c.kcp.SendFin()
+	c.kcp.Flush()
+	c.lock.Unlock()
+	notify(c.readNotify)
+	notify(c.writeNotify)
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.readDeadline = t
+	c.lock.Unlock()
+	notify(c.readNotify)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.writeDeadline = t
+	c.lock.Unlock()
+	notify(c.writeNotify)
+	return nil
+}
+
+func newConv() (uint32, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+// Dial connects to the kcp listener on addr, each conn owns an udp socket.
+func Dial(addr string, config *Config) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	conv, err := newConv()
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	c := newConn(conv, config, udpConn.LocalAddr(), raddr, func(b []byte) {
+		_, _ = udpConn.Write(b)
+	}, func() {
+		udpConn.Close()
+	})
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, err := udpConn.Read(buf)
+			if err != nil {
+				c.destroy(err)
+				return
+			}
+			c.input(buf[:n])
+		}
+	}()
+	return c, nil
+}
+
+// Listener accepts kcp conns on an udp socket, conns are told apart by the remote address.
+type Listener struct {
+	conn   *net.UDPConn
+	config *Config
+
+	conns    map[string]*Conn
+	connLock sync.Mutex
+	acceptCh chan *Conn
+
+	die     chan struct{}
+	dieOnce sync.Once
+	dieErr  error
+}
+
+func Listen(addr string, config *Config) (*Listener, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if config.MaxConns <= 0 {
+		c := *config
+		c.MaxConns = DefaultConfig().MaxConns
+		config = &c
+	}
+	l := &Listener{
+		conn:     udpConn,
+		config:   config,
+		conns:    make(map[string]*Conn),
+		acceptCh: make(chan *Conn, 128),
+		die:      make(chan struct{}),
+	}
+	go l.readLoop()
+	return l, nil
+}
+
+func (l *Listener) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			l.closeWithErr(err)
+			return
+		}
+		if n < overhead {
+			continue
+		}
+		if c := l.lookup(from, buf[:n]); c != nil {
+			c.input(buf[:n])
+		}
+	}
+}
+
+// lookup returns the conn of the packet, a new conn is created if it starts a stream.
+func (l *Listener) lookup(from *net.UDPAddr, data []byte) *Conn {
+	key := from.String()
+	conv := binary.LittleEndian.Uint32(data)
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	c, ok := l.conns[key]
+	if ok && c.conv == conv {
+		return c
+	}
+	if !isStreamStart(data) {
+		return nil
+	}
+	if !ok && len(l.conns) >= l.config.MaxConns {
+		return nil
+	}
+	if ok {
+		// the peer has dialed again from the same address
+		go c.destroy(ErrClosed)
+	}
+	var created *Conn
+	created = newConn(conv, l.config, l.conn.LocalAddr(), from, func(b []byte) {
+		_, _ = l.conn.WriteToUDP(b, from)
+	}, func() {
+		l.remove(key, created)
+	})
+	select {
+	case l.acceptCh <- created:
+		l.conns[key] = created
+		return created
+	default:
+		go created.destroy(ErrClosed)
+		return nil
+	}
+}
+
+func (l *Listener) remove(key string, c *Conn) {
+	l.connLock.Lock()
+	defer l.connLock.Unlock()
+	if l.conns[key] == c {
+		delete(l.conns, key)
+	}
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.AcceptKCP()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (l *Listener) AcceptKCP() (*Conn, error) {
+	select {
+	case c := <-l.acceptCh:
+		return c, nil
+	case <-l.die:
+		return nil, l.dieErr
+	}
+}
+
+// Close closes the udp socket and all conns accepted by it.
+func (l *Listener) Close() error {
+	l.closeWithErr(ErrClosed)
+	return nil
+}
+
+func (l *Listener) closeWithErr(err error) {
+	l.dieOnce.Do(func() {
+		l.dieErr = err
+		close(l.die)
+		l.conn.Close()
+		l.connLock.Lock()
+		conns := make([]*Conn, 0, len(l.conns))
+		for _, c := range l.conns {
+			conns = append(conns, c)
+		}
+		l.connLock.Unlock()
+		for _, c := range conns {
+			c.destroy(ErrClosed)
+		}
+	})
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,680 @@
+package kcp
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// The ARQ below follows the design of KCP (https://github.com/skywind3000/kcp) in stream mode,
+// with an extra FIN command so that the peer can tell when the stream ends.
+
+const (
+	rtoNoDelay = 30    // min rto in nodelay mode
+	rtoMin     = 100   // normal min rto
+	rtoDef     = 200   // initial rto
+	rtoMax     = 60000 // max rto
+
+	cmdPush = 81 // push data
+	cmdAck  = 82 // ack
+	cmdWask = 83 // window probe (ask)
+	cmdWins = 84 // window size (tell)
+	cmdFin  = 85 // end of stream, sequenced like push
+
+	askSend = 1 // need to send cmdWask
+	askTell = 2 // need to send cmdWins
+
+	wndSnd       = 32
+	wndRcv       = 128
+	mtuDef       = 1400
+	overhead     = 24
+	deadLink     = 20
+	threshInit   = 2
+	threshMin    = 2
+	probeInit    = 7000   // 7 secs to probe window size
+	probeLimit   = 120000 // up to 120 secs to probe window
+	intervalDef  = 100
+	stateDeadLnk = ^uint32(0)
+)
+
+var refTime = time.Now()
+
+// currentMs returns the milliseconds since the process started, it's the clock of KCP.
+func currentMs() uint32 {
+	return uint32(time.Since(refTime) / time.Millisecond)
+}
+
+func timediff(later, earlier uint32) int32 {
+	return int32(later - earlier)
+}
+
+type segment struct {
+	conv     uint32
+	cmd      uint8
+	frg      uint8
+	wnd      uint16
+	ts       uint32
+	sn       uint32
+	una      uint32
+	resendts uint32
+	rto      uint32
+	fastack  uint32
+	xmit     uint32
+	data     []byte
+}
+
+func (seg *segment) encode(b []byte) []byte {
+	var hdr [overhead]byte
+	binary.LittleEndian.PutUint32(hdr[0:], seg.conv)
+	hdr[4] = seg.cmd
+	hdr[5] = seg.frg
+	binary.LittleEndian.PutUint16(hdr[6:], seg.wnd)
+	binary.LittleEndian.PutUint32(hdr[8:], seg.ts)
+	binary.LittleEndian.PutUint32(hdr[12:], seg.sn)
+	binary.LittleEndian.PutUint32(hdr[16:], seg.una)
+	binary.LittleEndian.PutUint32(hdr[20:], uint32(len(seg.data)))
+	b = append(b, hdr[:]...)
+	return append(b, seg.data...)
+}
+
+// isStreamStart reports whether the packet carries the first segment of a stream,
+// only such packets are allowed to create a connection on the listener.
+func isStreamStart(data []byte) bool {
+	for len(data) >= overhead {
+		cmd := data[4]
+		sn := binary.LittleEndian.Uint32(data[12:])
+		length := binary.LittleEndian.Uint32(data[20:])
+		if (cmd == cmdPush || cmd == cmdFin) && sn == 0 {
+			return true
+		}
+		if uint32(len(data)-overhead) < length {
+			return false
+		}
+		data = data[overhead+length:]
+	}
+	return false
+}
+
+// KCP is the control block of one stream, it's not safe for concurrent use.
+type KCP struct {
+	conv, mtu, mss, state               uint32
+	sndUna, sndNxt, rcvNxt              uint32
+	ssthresh                            uint32
+	rxRttvar, rxSrtt                    int32
+	rxRto, rxMinrto                     uint32
+	sndWnd, rcvWnd, rmtWnd, cwnd, probe uint32
+	current, interval, tsFlush          uint32
+	nodelay, updated                    uint32
+	tsProbe, probeWait                  uint32
+	incr                                uint32
+	fastresend                          int32
+	nocwnd                              bool
+	finSent, finArrived, eof            bool
+	sndQueue, rcvQueue, sndBuf, rcvBuf  []segment
+	acklist                             []uint32
+	buffer                              []byte
+	output                              func(buf []byte)
+}
+
+// NewKCP creates the control block, output is called with every packet to be sent.
+func NewKCP(conv uint32, output func(buf []byte)) *KCP {
+	k := &KCP{
+		conv:     conv,
+		sndWnd:   wndSnd,
+		rcvWnd:   wndRcv,
+		rmtWnd:   wndRcv,
+		mtu:      mtuDef,
+		mss:      mtuDef - overhead,
+		rxRto:    rtoDef,
+		rxMinrto: rtoMin,
+		interval: intervalDef,
+		tsFlush:  intervalDef,
+		ssthresh: threshInit,
+		output:   output,
+	}
+	k.buffer = make([]byte, 0, k.mtu)
+	return k
+}
+
+// SetNoDelay configures the fast mode, interval is the update interval in milliseconds,
+// resend triggers fast retransmission after that many out-of-order acks, 0 disables it.
+func (k *KCP) SetNoDelay(nodelay bool, interval, resend int, nc bool) {
+	if nodelay {
+		k.nodelay = 1
+		k.rxMinrto = rtoNoDelay
+	} else {
+		k.nodelay = 0
+		k.rxMinrto = rtoMin
+	}
+	if interval > 5000 {
+		interval = 5000
+	} else if interval < 10 {
+		interval = 10
+	}
+	k.interval = uint32(interval)
+	if resend >= 0 {
+		k.fastresend = int32(resend)
+	}
+	k.nocwnd = nc
+}
+
+// SetWndSize sets the max send and receive window in packets.
+func (k *KCP) SetWndSize(sndwnd, rcvwnd int) {
+	if sndwnd > 0 {
+		k.sndWnd = uint32(sndwnd)
+	}
+	if rcvwnd > 0 {
+		k.rcvWnd = uint32(rcvwnd)
+		if k.rcvWnd < wndRcv {
+			k.rcvWnd = wndRcv
+		}
+	}
+}
+
+func (k *KCP) SetMtu(mtu int) bool {
+	if mtu < 50 || mtu < overhead {
+		return false
+	}
+	k.mtu = uint32(mtu)
+	k.mss = k.mtu - overhead
+	k.buffer = make([]byte, 0, k.mtu)
+	return true
+}
+
+// Recv reads the ordered stream into buf, returns 0 if nothing is available.
+func (k *KCP) Recv(buf []byte) int {
+	fastRecover := uint32(len(k.rcvQueue)) >= k.rcvWnd
+	n := 0
+	for n < len(buf) && len(k.rcvQueue) > 0 {
+		seg := &k.rcvQueue[0]
+		if seg.cmd == cmdFin {
+			k.eof = true
+			k.rcvQueue = k.rcvQueue[1:]
+			break
+		}
+		c := copy(buf[n:], seg.data)
+		n += c
+		seg.data = seg.data[c:]
+		if len(seg.data) == 0 {
+			k.rcvQueue = k.rcvQueue[1:]
+		}
+	}
+	k.moveRcvBuf()
+	// the window was full, tell the peer it's opened again
+	if fastRecover && uint32(len(k.rcvQueue)) < k.rcvWnd {
+		k.probe |= askTell
+	}
+	return n
+}
+
+// discardRecv drops the received data nobody will read, so that FIN can still arrive.
+func (k *KCP) discardRecv() {
+	k.rcvQueue = k.rcvQueue[:0]
+	k.moveRcvBuf()
+}
+
+// Send appends data to the stream, it's split into segments of mss.
+func (k *KCP) Send(buf []byte) {
+	// stream mode, fill the last segment first
+	if n := len(k.sndQueue); n > 0 {
+		seg := &k.sndQueue[n-1]
+		if seg.cmd != cmdFin && uint32(len(seg.data)) < k.mss {
+			c := int(k.mss) - len(seg.data)
+			if c > len(buf) {
+				c = len(buf)
+			}
+			seg.data = append(seg.data, buf[:c]...)
+			buf = buf[c:]
+		}
+	}
+	for len(buf) > 0 {
+		size := len(buf)
+		if size > int(k.mss) {
+			size = int(k.mss)
+		}
+		data := make([]byte, size, k.mss)
+		copy(data, buf)
+		k.sndQueue = append(k.sndQueue, segment{cmd: cmdPush, data: data})
+		buf = buf[size:]
+	}
+}
+
+// SendFin ends the stream, the peer reads EOF after all data sent before.
+func (k *KCP) SendFin() {
+	if k.finSent {
+		return
+	}
+	k.finSent = true
+	k.sndQueue = append(k.sndQueue, segment{cmd: cmdFin})
+}
+
+// Eof reports whether the peer's FIN has been read by Recv.
+func (k *KCP) Eof() bool {
+	return k.eof
+}
+
+// Finished reports whether both sides have sent FIN and all sent data is acknowledged.
+func (k *KCP) Finished() bool {
+	return k.finSent && k.finArrived && len(k.sndQueue) == 0 && len(k.sndBuf) == 0
+}
+
+// IsDead reports whether a segment is retransmitted too many times.
+func (k *KCP) IsDead() bool {
+	return k.state == stateDeadLnk
+}
+
+// WaitSnd is the number of segments waiting to be sent or acknowledged.
+func (k *KCP) WaitSnd() int {
+	return len(k.sndBuf) + len(k.sndQueue)
+}
+
+// Probe asks the peer for its window, the answer keeps the connection alive.
+func (k *KCP) Probe() {
+	k.probe |= askSend
+}
+
+func (k *KCP) updateAck(rtt int32) {
+	if k.rxSrtt == 0 {
+		k.rxSrtt = rtt
+		k.rxRttvar = rtt / 2
+	} else {
+		delta := rtt - k.rxSrtt
+		if delta < 0 {
+			delta = -delta
+		}
+		k.rxRttvar = (3*k.rxRttvar + delta) / 4
+		k.rxSrtt = (7*k.rxSrtt + rtt) / 8
+		if k.rxSrtt < 1 {
+			k.rxSrtt = 1
+		}
+	}
+	rto := uint32(k.rxSrtt) + max32(k.interval, uint32(4*k.rxRttvar))
+	k.rxRto = bound32(k.rxMinrto, rto, rtoMax)
+}
+
+func (k *KCP) shrinkBuf() {
+	if len(k.sndBuf) > 0 {
+		k.sndUna = k.sndBuf[0].sn
+	} else {
+		k.sndUna = k.sndNxt
+	}
+}
+
+func (k *KCP) parseAck(sn uint32) {
+	if timediff(sn, k.sndUna) < 0 || timediff(sn, k.sndNxt) >= 0 {
+		return
+	}
+	for i := range k.sndBuf {
+		seg := &k.sndBuf[i]
+		if sn == seg.sn {
+			k.sndBuf = append(k.sndBuf[:i], k.sndBuf[i+1:]...)
+			break
+		}
+		if timediff(sn, seg.sn) < 0 {
+			break
+		}
+	}
+}
+
+func (k *KCP) parseFastack(sn, ts uint32) {
+	if timediff(sn, k.sndUna) < 0 || timediff(sn, k.sndNxt) >= 0 {
+		return
+	}
+	for i := range k.sndBuf {
+		seg := &k.sndBuf[i]
+		if timediff(sn, seg.sn) < 0 {
+			break
+		} else if sn != seg.sn && timediff(seg.ts, ts) <= 0 {
+			seg.fastack++
+		}
+	}
+}
+
+func (k *KCP) parseUna(una uint32) {
+	count := 0
+	for i := range k.sndBuf {
+		if timediff(una, k.sndBuf[i].sn) > 0 {
+			count++
+		} else {
+			break
+		}
+	}
+	if count > 0 {
+		k.sndBuf = k.sndBuf[count:]
+	}
+}
+
+func (k *KCP) ackPush(sn, ts uint32) {
+	k.acklist = append(k.acklist, sn, ts)
+}
+
+func (k *KCP) parseData(newseg segment) {
+	sn := newseg.sn
+	if timediff(sn, k.rcvNxt+k.rcvWnd) >= 0 || timediff(sn, k.rcvNxt) < 0 {
+		return
+	}
+	insert := len(k.rcvBuf)
+	for i := len(k.rcvBuf) - 1; i >= 0; i-- {
+		seg := &k.rcvBuf[i]
+		if seg.sn == sn {
+			return
+		}
+		if timediff(sn, seg.sn) > 0 {
+			break
+		}
+		insert = i
+	}
+	// the input buffer is reused by the caller
+	newseg.data = append([]byte(nil), newseg.data...)
+	k.rcvBuf = append(k.rcvBuf, segment{})
+	copy(k.rcvBuf[insert+1:], k.rcvBuf[insert:])
+	k.rcvBuf[insert] = newseg
+	k.moveRcvBuf()
+}
+
+// moveRcvBuf moves the continuous segments to rcvQueue.
+func (k *KCP) moveRcvBuf() {
+	count := 0
+	for i := range k.rcvBuf {
+		seg := &k.rcvBuf[i]
+		if seg.sn != k.rcvNxt || uint32(len(k.rcvQueue)+count) >= k.rcvWnd {
+			break
+		}
+		if seg.cmd == cmdFin {
+			k.finArrived = true
+		}
+		k.rcvNxt++
+		count++
+	}
+	if count > 0 {
+		k.rcvQueue = append(k.rcvQueue, k.rcvBuf[:count]...)
+		k.rcvBuf = k.rcvBuf[count:]
+	}
+}
+
+// Input feeds a packet received from the peer, returns a negative value if it's invalid.
+func (k *KCP) Input(data []byte) int {
+	prevUna := k.sndUna
+	var maxack, latest uint32
+	flag := false
+	if len(data) < overhead {
+		return -1
+	}
+	k.current = currentMs()
+	for len(data) >= overhead {
+		conv := binary.LittleEndian.Uint32(data[0:])
+		cmd := data[4]
+		frg := data[5]
+		wnd := binary.LittleEndian.Uint16(data[6:])
+		ts := binary.LittleEndian.Uint32(data[8:])
+		sn := binary.LittleEndian.Uint32(data[12:])
+		una := binary.LittleEndian.Uint32(data[16:])
+		length := binary.LittleEndian.Uint32(data[20:])
+		data = data[overhead:]
+		if conv != k.conv {
+			return -1
+		}
+		if uint32(len(data)) < length {
+			return -2
+		}
+		if cmd != cmdPush && cmd != cmdAck && cmd != cmdWask && cmd != cmdWins && cmd != cmdFin {
+			return -3
+		}
+		k.rmtWnd = uint32(wnd)
+		k.parseUna(una)
+		k.shrinkBuf()
+		switch cmd {
+		case cmdAck:
+			if timediff(k.current, ts) >= 0 {
+				k.updateAck(timediff(k.current, ts))
+			}
+			k.parseAck(sn)
+			k.shrinkBuf()
+			if !flag {
+				flag = true
+				maxack, latest = sn, ts
+			} else if timediff(sn, maxack) > 0 {
+				maxack, latest = sn, ts
+			}
+		case cmdPush, cmdFin:
+			if timediff(sn, k.rcvNxt+k.rcvWnd) < 0 {
+				k.ackPush(sn, ts)
+				if timediff(sn, k.rcvNxt) >= 0 {
+					k.parseData(segment{conv: conv, cmd: cmd, frg: frg, wnd: wnd, ts: ts, sn: sn, una: una, data: data[:length]})
+				}
+			}
+		case cmdWask:
+			k.probe |= askTell
+		case cmdWins:
+			// the window is updated above
+		}
+		data = data[length:]
+	}
+	if flag {
+		k.parseFastack(maxack, latest)
+	}
+
+	if timediff(k.sndUna, prevUna) > 0 && k.cwnd < k.rmtWnd {
+		mss := k.mss
+		if k.cwnd < k.ssthresh {
+			k.cwnd++
+			k.incr += mss
+		} else {
+			if k.incr < mss {
+				k.incr = mss
+			}
+			k.incr += (mss*mss)/k.incr + (mss / 16)
+			if (k.cwnd+1)*mss <= k.incr {
+				k.cwnd = (k.incr + mss - 1) / mss
+			}
+		}
+		if k.cwnd > k.rmtWnd {
+			k.cwnd = k.rmtWnd
+			k.incr = k.rmtWnd * mss
+		}
+	}
+	return 0
+}
+
+func (k *KCP) wndUnused() uint16 {
+	if uint32(len(k.rcvQueue)) < k.rcvWnd {
+		return uint16(k.rcvWnd - uint32(len(k.rcvQueue)))
+	}
+	return 0
+}
+
+// makeSpace flushes the buffer if there is no room for size bytes.
+func (k *KCP) makeSpace(size int) {
+	if len(k.buffer)+size > int(k.mtu) {
+		k.output(k.buffer)
+		k.buffer = k.buffer[:0]
+	}
+}
+
+// Flush sends pending acks, probes and the segments allowed by the window.
+func (k *KCP) Flush() {
+	if k.updated == 0 {
+		return
+	}
+	k.current = currentMs()
+	current := k.current
+	seg := segment{conv: k.conv, cmd: cmdAck, wnd: k.wndUnused(), una: k.rcvNxt}
+
+	for i := 0; i+1 < len(k.acklist); i += 2 {
+		k.makeSpace(overhead)
+		seg.sn, seg.ts = k.acklist[i], k.acklist[i+1]
+		k.buffer = seg.encode(k.buffer)
+	}
+	k.acklist = k.acklist[:0]
+
+	// probe window size if the remote window is zero
+	if k.rmtWnd == 0 {
+		if k.probeWait == 0 {
+			k.probeWait = probeInit
+			k.tsProbe = current + k.probeWait
+		} else if timediff(current, k.tsProbe) >= 0 {
+			if k.probeWait < probeInit {
+				k.probeWait = probeInit
+			}
+			k.probeWait += k.probeWait / 2
+			if k.probeWait > probeLimit {
+				k.probeWait = probeLimit
+			}
+			k.tsProbe = current + k.probeWait
+			k.probe |= askSend
+		}
+	} else {
+		k.tsProbe = 0
+		k.probeWait = 0
+	}
+	seg.sn, seg.ts = 0, 0
+	if k.probe&askSend != 0 {
+		seg.cmd = cmdWask
+		k.makeSpace(overhead)
+		k.buffer = seg.encode(k.buffer)
+	}
+	if k.probe&askTell != 0 {
+		seg.cmd = cmdWins
+		k.makeSpace(overhead)
+		k.buffer = seg.encode(k.buffer)
+	}
+	k.probe = 0
+
+	cwnd := min32(k.sndWnd, k.rmtWnd)
+	if !k.nocwnd {
+		cwnd = min32(k.cwnd, cwnd)
+	}
+	// move data from sndQueue to sndBuf
+	count := 0
+	for i := range k.sndQueue {
+		if timediff(k.sndNxt, k.sndUna+cwnd) >= 0 {
+			break
+		}
+		newseg := k.sndQueue[i]
+		newseg.conv = k.conv
+		newseg.wnd = seg.wnd
+		newseg.ts = current
+		newseg.sn = k.sndNxt
+		newseg.una = k.rcvNxt
+		newseg.resendts = current
+		newseg.rto = k.rxRto
+		newseg.fastack = 0
+		newseg.xmit = 0
+		k.sndBuf = append(k.sndBuf, newseg)
+		k.sndNxt++
+		count++
+	}
+	if count > 0 {
+		k.sndQueue = k.sndQueue[count:]
+	}
+
+	resent := uint32(k.fastresend)
+	if k.fastresend <= 0 {
+		resent = 0xffffffff
+	}
+	var rtomin uint32
+	if k.nodelay == 0 {
+		rtomin = k.rxRto >> 3
+	}
+	lost, change := false, false
+	for i := range k.sndBuf {
+		sseg := &k.sndBuf[i]
+		needsend := false
+		if sseg.xmit == 0 {
+			needsend = true
+			sseg.xmit++
+			sseg.rto = k.rxRto
+			sseg.resendts = current + sseg.rto + rtomin
+		} else if timediff(current, sseg.resendts) >= 0 {
+			needsend = true
+			sseg.xmit++
+			if k.nodelay == 0 {
+				sseg.rto += max32(sseg.rto, k.rxRto)
+			} else {
+				sseg.rto += k.rxRto / 2
+			}
+			sseg.resendts = current + sseg.rto
+			lost = true
+		} else if sseg.fastack >= resent {
+			needsend = true
+			sseg.xmit++
+			sseg.fastack = 0
+			sseg.resendts = current + sseg.rto
+			change = true
+		}
+		if needsend {
+			sseg.ts = current
+			sseg.wnd = seg.wnd
+			sseg.una = k.rcvNxt
+			k.makeSpace(overhead + len(sseg.data))
+			k.buffer = sseg.encode(k.buffer)
+			if sseg.xmit >= deadLink {
+				k.state = stateDeadLnk
+			}
+		}
+	}
+	if len(k.buffer) > 0 {
+		k.output(k.buffer)
+		k.buffer = k.buffer[:0]
+	}
+
+	if change {
+		inflight := k.sndNxt - k.sndUna
+		k.ssthresh = inflight / 2
+		if k.ssthresh < threshMin {
+			k.ssthresh = threshMin
+		}
+		k.cwnd = k.ssthresh + resent
+		k.incr = k.cwnd * k.mss
+	}
+	if lost {
+		k.ssthresh = cwnd / 2
+		if k.ssthresh < threshMin {
+			k.ssthresh = threshMin
+		}
+		k.cwnd = 1
+		k.incr = k.mss
+	}
+	if k.cwnd < 1 {
+		k.cwnd = 1
+		k.incr = k.mss
+	}
+}
+
+// Update drives the timers, it's expected to be called every interval.
+func (k *KCP) Update() {
+	k.current = currentMs()
+	if k.updated == 0 {
+		k.updated = 1
+		k.tsFlush = k.current
+	}
+	slap := timediff(k.current, k.tsFlush)
+	if slap >= 10000 || slap < -10000 {
+		k.tsFlush = k.current
+		slap = 0
+	}
+	if slap >= 0 {
+		k.tsFlush += k.interval
+		if timediff(k.current, k.tsFlush) >= 0 {
+			k.tsFlush = k.current + k.interval
+		}
+		k.Flush()
+	}
+}
+
+func min32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func bound32(lower, middle, upper uint32) uint32 {
+	return min32(max32(lower, middle), upper)
+}
@@ -0,0 +1,140 @@
+package kcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func testListener(t *testing.T, config *Config) *Listener {
+	l, err := Listen("127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// acceptTimeout returns the next accepted conn, or nil if there is none before the timeout.
+func acceptTimeout(l *Listener, timeout time.Duration) *Conn {
+	accepted := make(chan *Conn, 1)
+	go func() {
+		c, err := l.AcceptKCP()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+	select {
+	case c := <-accepted:
+		return c
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestEcho(t *testing.T) {
+	l := testListener(t, nil)
+	go func() {
+		c, err := l.AcceptKCP()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_, _ = io.Copy(c, c)
+	}()
+	c, err := Dial(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// much larger than the mtu so that it's split into many segments
+	payload := bytes.Repeat([]byte("breaker"), 50000)
+	go func() {
+		_, _ = c.Write(payload)
+	}()
+	got := make([]byte, len(payload))
+	_ = c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("echoed payload mismatch")
+	}
+}
+
+func TestListenerMaxConns(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConns = 1
+	l := testListener(t, config)
+	first, err := Dial(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if _, err := first.Write([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if acceptTimeout(l, 2*time.Second) == nil {
+		t.Fatal("the first conn is not accepted")
+	}
+	second, err := Dial(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if _, err := second.Write([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if acceptTimeout(l, 500*time.Millisecond) != nil {
+		t.Fatal("the conn over max_conns is accepted")
+	}
+}
+
+func TestListenerDropsMidStream(t *testing.T) {
+	l := testListener(t, nil)
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a push segment in the middle of a stream can't create a conn
+	seg := segment{conv: 1, cmd: cmdPush, sn: 7, data: []byte("spoofed")}
+	if _, err := conn.Write(seg.encode(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if acceptTimeout(l, 500*time.Millisecond) != nil {
+		t.Fatal("a mid stream packet created a conn")
+	}
+}
+
+func TestIsStreamStart(t *testing.T) {
+	start := segment{conv: 1, cmd: cmdPush, sn: 0, data: []byte("x")}
+	ack := segment{conv: 1, cmd: cmdAck, sn: 0}
+	mid := segment{conv: 1, cmd: cmdPush, sn: 3}
+	// the ack claims more data than the packet has, so the push after it is not parsed
+	truncated := ack.encode(nil)
+	binary.LittleEndian.PutUint32(truncated[20:], 100)
+	truncated = start.encode(truncated)
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"push sn 0", start.encode(nil), true},
+		{"ack then push sn 0", start.encode(ack.encode(nil)), true},
+		{"ack only", ack.encode(nil), false},
+		{"mid stream", mid.encode(nil), false},
+		{"truncated", truncated, false},
+		{"empty", nil, false},
+	}
+	for _, tt := range tests {
+		if got := isStreamStart(tt.data); got != tt.want {
+			t.Errorf("%s: isStreamStart() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
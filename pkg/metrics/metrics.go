@@ -0,0 +1,257 @@
+// Package metrics exposes counters, gauges and histograms in the text format
+// of Prometheus, it only implements what the portal and bridge need.
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// types of metric families
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// DefBuckets are the upper bounds in seconds for durations from milliseconds to seconds.
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// value is a float64 updated atomically.
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, next) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Counter only goes up, it's reset when the process restarts.
+type Counter struct {
+	v value
+}
+
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add increases the counter, negative delta is ignored.
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.v.add(delta)
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return c.v.get()
+}
+
+// Gauge goes up and down.
+type Gauge struct {
+	v value
+}
+
+func (g *Gauge) Set(f float64) {
+	g.v.set(f)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.v.get()
+}
+
+// Histogram counts observations in buckets by upper bound.
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+	lock    sync.Mutex
+}
+
+// NewHistogram creates a histogram with sorted upper bounds, DefBuckets is used if it's empty.
+func NewHistogram(buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(f float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if i := sort.SearchFloat64s(h.buckets, f); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += f
+}
+
+// snapshot returns the cumulative counts of buckets, the count and the sum.
+func (h *Histogram) snapshot() ([]uint64, uint64, float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	cumulative := make([]uint64, len(h.counts))
+	var total uint64
+	for i, n := range h.counts {
+		total += n
+		cumulative[i] = total
+	}
+	return cumulative, h.count, h.sum
+}
+
+// vec keeps the children of a metric family by label values.
+type vec struct {
+	name     string
+	help     string
+	labels   []string
+	children map[string]interface{}
+	values   map[string][]string
+	newChild func() interface{}
+	lock     sync.RWMutex
+}
+
+func newVec(name, help string, labels []string, newChild func() interface{}) *vec {
+	return &vec{
+		name:     name,
+		help:     help,
+		labels:   labels,
+		children: make(map[string]interface{}),
+		values:   make(map[string][]string),
+		newChild: newChild,
+	}
+}
+
+func (v *vec) with(values []string) interface{} {
+	if len(values) != len(v.labels) {
+		panic("metrics: " + v.name + " expects labels " + strings.Join(v.labels, ","))
+	}
+	key := strings.Join(values, "\xff")
+	v.lock.RLock()
+	child, ok := v.children[key]
+	v.lock.RUnlock()
+	if ok {
+		return child
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if child, ok := v.children[key]; ok {
+		return child
+	}
+	child = v.newChild()
+	v.children[key] = child
+	v.values[key] = append([]string(nil), values...)
+	return child
+}
+
+func (v *vec) delete(values []string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	key := strings.Join(values, "\xff")
+	delete(v.children, key)
+	delete(v.values, key)
+}
+
+// each calls f for every child sorted by label values.
+func (v *vec) each(f func(labels Labels, child interface{})) {
+	v.lock.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	v.lock.RUnlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		v.lock.RLock()
+		child, ok := v.children[key]
+		values := v.values[key]
+		v.lock.RUnlock()
+		if !ok {
+			continue
+		}
+		f(NewLabels(v.labels, values), child)
+	}
+}
+
+// CounterVec is a family of counters partitioned by labels.
+type CounterVec struct {
+	*vec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, labels, func() interface{} { return &Counter{} })}
+}
+
+// With returns the counter of the label values, it's created on first use.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values).(*Counter)
+}
+
+func (c *CounterVec) Delete(values ...string) {
+	c.delete(values)
+}
+
+func (c *CounterVec) Collect(e *Encoder) {
+	e.Family(c.name, c.help, TypeCounter)
+	c.each(func(labels Labels, child interface{}) {
+		e.Sample(c.name, labels, child.(*Counter).Value())
+	})
+}
+
+// GaugeVec is a family of gauges partitioned by labels.
+type GaugeVec struct {
+	*vec
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, labels, func() interface{} { return &Gauge{} })}
+}
+
+// With returns the gauge of the label values, it's created on first use.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.with(values).(*Gauge)
+}
+
+func (g *GaugeVec) Delete(values ...string) {
+	g.delete(values)
+}
+
+func (g *GaugeVec) Collect(e *Encoder) {
+	e.Family(g.name, g.help, TypeGauge)
+	g.each(func(labels Labels, child interface{}) {
+		e.Sample(g.name, labels, child.(*Gauge).Value())
+	})
+}
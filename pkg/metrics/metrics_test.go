@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGather(t *testing.T) {
+	conns := NewCounterVec("conns_total", "Number of conns.", "proxy")
+	conns.With("web").Add(3)
+	conns.With("ssh").Inc()
+	conns.With("ssh").Add(-1)
+	active := NewGaugeVec("active", "Active conns,\nby proxy.", "proxy")
+	active.With(`a"b\c`).Set(2)
+	active.With(`a"b\c`).Dec()
+	h := NewHistogram([]float64{1, 0.5})
+	h.Observe(0.2)
+	h.Observe(0.7)
+	h.Observe(2)
+
+	registry := NewRegistry()
+	registry.Register(conns, active, CollectorFunc(func(e *Encoder) {
+		e.Family("up", "Whether it's up.", TypeGauge)
+		e.Sample("up", L(), 1)
+		e.Family("wait_seconds", "Wait time.", TypeHistogram)
+		e.Histogram("wait_seconds", L("proxy", "web"), h)
+	}))
+	want := strings.Join([]string{
+		"# HELP conns_total Number of conns.",
+		"# TYPE conns_total counter",
+		`conns_total{proxy="ssh"} 1`,
+		`conns_total{proxy="web"} 3`,
+		`# HELP active Active conns,\nby proxy.`,
+		"# TYPE active gauge",
+		`active{proxy="a\"b\\c"} 1`,
+		"# HELP up Whether it's up.",
+		"# TYPE up gauge",
+		"up 1",
+		"# HELP wait_seconds Wait time.",
+		"# TYPE wait_seconds histogram",
+		`wait_seconds_bucket{proxy="web",le="0.5"} 1`,
+		`wait_seconds_bucket{proxy="web",le="1"} 2`,
+		`wait_seconds_bucket{proxy="web",le="+Inf"} 3`,
+		`wait_seconds_sum{proxy="web"} 2.9`,
+		`wait_seconds_count{proxy="web"} 3`,
+	}, "\n") + "\n"
+	if got := string(registry.Gather()); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type:%s", ct)
+	}
+	if rec.Body.String() != want {
+		t.Fatal("served metrics mismatch")
+	}
+}
+
+func TestVecDelete(t *testing.T) {
+	traffic := NewCounterVec("traffic_bytes_total", "Bytes.", "proxy", "direction")
+	traffic.With("web", "in").Add(10)
+	traffic.With("web", "out").Add(20)
+	traffic.With("ssh", "in").Add(30)
+	traffic.Delete("web", "in")
+	traffic.Delete("web", "out")
+	e := &Encoder{}
+	traffic.Collect(e)
+	want := "# HELP traffic_bytes_total Bytes.\n" +
+		"# TYPE traffic_bytes_total counter\n" +
+		`traffic_bytes_total{proxy="ssh",direction="in"} 30` + "\n"
+	if got := e.buf.String(); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if v := traffic.With("web", "in").Value(); v != 0 {
+		t.Fatalf("deleted counter is recreated with %v", v)
+	}
+}
+
+func TestWithWrongLabels(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic with wrong number of labels")
+		}
+	}()
+	NewGaugeVec("g", "G.", "a", "b").With("x")
+}
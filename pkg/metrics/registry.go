@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector writes its metric families when metrics are scraped.
+type Collector interface {
+	Collect(e *Encoder)
+}
+
+// CollectorFunc collects metrics read at scrape time, e.g. the sizes of queues.
+type CollectorFunc func(e *Encoder)
+
+func (f CollectorFunc) Collect(e *Encoder) {
+	f(e)
+}
+
+// Registry serves the registered collectors at /metrics.
+type Registry struct {
+	collectors []Collector
+	lock       sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Register(collectors ...Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// Gather returns the text format of all collectors.
+func (r *Registry) Gather() []byte {
+	r.lock.Lock()
+	collectors := append([]Collector(nil), r.collectors...)
+	r.lock.Unlock()
+	e := &Encoder{}
+	for _, c := range collectors {
+		c.Collect(e)
+	}
+	return e.buf.Bytes()
+}
+
+func (r *Registry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rw.Write(r.Gather())
+}
+
+// Labels are the names and values of labels in order.
+type Labels struct {
+	names  []string
+	values []string
+}
+
+func NewLabels(names []string, values []string) Labels {
+	return Labels{names: names, values: values}
+}
+
+// L creates labels from pairs of name and value.
+func L(pairs ...string) Labels {
+	var l Labels
+	for i := 0; i+1 < len(pairs); i += 2 {
+		l.names = append(l.names, pairs[i])
+		l.values = append(l.values, pairs[i+1])
+	}
+	return l
+}
+
+// With returns a copy of the labels with another pair appended.
+func (l Labels) With(name, value string) Labels {
+	return Labels{
+		names:  append(append([]string(nil), l.names...), name),
+		values: append(append([]string(nil), l.values...), value),
+	}
+}
+
+// Encoder writes metrics in the text exposition format.
+type Encoder struct {
+	buf bytes.Buffer
+}
+
+// Family writes the HELP and TYPE lines, samples of the family must follow it.
+func (e *Encoder) Family(name, help, typ string) {
+	e.buf.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	e.buf.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+func (e *Encoder) Sample(name string, labels Labels, v float64) {
+	e.buf.WriteString(name)
+	if len(labels.names) > 0 {
+		e.buf.WriteByte('{')
+		for i, n := range labels.names {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			e.buf.WriteString(n + `="` + escapeLabel(labels.values[i]) + `"`)
+		}
+		e.buf.WriteByte('}')
+	}
+	e.buf.WriteString(" " + formatFloat(v) + "\n")
+}
+
+// Histogram writes the buckets, the sum and the count of h.
+func (e *Encoder) Histogram(name string, labels Labels, h *Histogram) {
+	cumulative, count, sum := h.snapshot()
+	for i, upper := range h.buckets {
+		e.Sample(name+"_bucket", labels.With("le", formatFloat(upper)), float64(cumulative[i]))
+	}
+	e.Sample(name+"_bucket", labels.With("le", "+Inf"), float64(count))
+	e.Sample(name+"_sum", labels, sum)
+	e.Sample(name+"_count", labels, float64(count))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
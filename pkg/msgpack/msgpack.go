@@ -0,0 +1,619 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnsupportedType = errors.New("msgpack: unsupported type")
+	ErrInvalidFormat   = errors.New("msgpack: invalid format")
+	ErrNotPointer      = errors.New("msgpack: decode target must be a non-nil pointer")
+)
+
+// Marshal returns the MessagePack encoding of v.
+// Structs are encoded as maps keyed by field name, the name can be changed by `msgpack:"name"` tag,
+// and `msgpack:"-"` skips the field. Anonymous struct fields are flattened like encoding/json does.
+func Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	e := &encoder{w: buf}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the MessagePack data into v, v must be a non-nil pointer.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrNotPointer
+	}
+	d := &decoder{r: bytes.NewReader(data)}
+	x, err := d.decode()
+	if err != nil {
+		return err
+	}
+	return assign(rv.Elem(), x)
+}
+
+type field struct {
+	name  string
+	index []int
+}
+
+var fieldCache sync.Map
+
+// structFields returns the encodable fields of t, embedded structs are flattened.
+func structFields(t reflect.Type) []field {
+	if v, ok := fieldCache.Load(t); ok {
+		return v.([]field)
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("msgpack")
+		if tag == "-" {
+			continue
+		}
+		if sf.Anonymous && tag == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for _, f := range structFields(ft) {
+					fields = append(fields, field{name: f.name, index: append([]int{i}, f.index...)})
+				}
+				continue
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := sf.Name
+		if tag != "" {
+			name = strings.Split(tag, ",")[0]
+		}
+		fields = append(fields, field{name: name, index: []int{i}})
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but returns false on nil embedded pointers
+// instead of panicking. When alloc is set, nil embedded pointers are allocated.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+type encoder struct {
+	w *bytes.Buffer
+}
+
+func (e *encoder) writeByte(b byte) {
+	e.w.WriteByte(b)
+}
+
+func (e *encoder) writeUint(code byte, size int, n uint64) {
+	e.writeByte(code)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	e.w.Write(b[8-size:])
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.writeByte(0xc0)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.writeByte(0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.writeByte(0xc3)
+		} else {
+			e.writeByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.writeUint(0xca, 4, uint64(math.Float32bits(float32(v.Float()))))
+	case reflect.Float64:
+		e.writeUint(0xcb, 8, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.writeByte(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBytes(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.writeByte(0xc0)
+			return nil
+		}
+		e.encodeLen(0x80, 0xde, 0xdf, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, v.Type())
+	}
+	return nil
+}
+
+func (e *encoder) encodeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.encodeUint(uint64(n))
+	case n >= -32:
+		e.writeByte(byte(n))
+	case n >= math.MinInt8:
+		e.writeUint(0xd0, 1, uint64(n))
+	case n >= math.MinInt16:
+		e.writeUint(0xd1, 2, uint64(n))
+	case n >= math.MinInt32:
+		e.writeUint(0xd2, 4, uint64(n))
+	default:
+		e.writeUint(0xd3, 8, uint64(n))
+	}
+}
+
+func (e *encoder) encodeUint(n uint64) {
+	switch {
+	case n <= math.MaxInt8:
+		e.writeByte(byte(n))
+	case n <= math.MaxUint8:
+		e.writeUint(0xcc, 1, n)
+	case n <= math.MaxUint16:
+		e.writeUint(0xcd, 2, n)
+	case n <= math.MaxUint32:
+		e.writeUint(0xce, 4, n)
+	default:
+		e.writeUint(0xcf, 8, n)
+	}
+}
+
+func (e *encoder) encodeString(s string) {
+	switch n := len(s); {
+	case n < 32:
+		e.writeByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		e.writeUint(0xd9, 1, uint64(n))
+	case n <= math.MaxUint16:
+		e.writeUint(0xda, 2, uint64(n))
+	default:
+		e.writeUint(0xdb, 4, uint64(n))
+	}
+	e.w.WriteString(s)
+}
+
+func (e *encoder) encodeBytes(b []byte) {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		e.writeUint(0xc4, 1, uint64(n))
+	case n <= math.MaxUint16:
+		e.writeUint(0xc5, 2, uint64(n))
+	default:
+		e.writeUint(0xc6, 4, uint64(n))
+	}
+	e.w.Write(b)
+}
+
+// encodeLen writes the header of array or map, fix is the fix-format prefix.
+func (e *encoder) encodeLen(fix, code16, code32 byte, n int) {
+	switch {
+	case n < 16:
+		e.writeByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		e.writeUint(code16, 2, uint64(n))
+	default:
+		e.writeUint(code32, 4, uint64(n))
+	}
+}
+
+func (e *encoder) encodeArray(v reflect.Value) error {
+	e.encodeLen(0x90, 0xdc, 0xdd, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeStruct(v reflect.Value) error {
+	fields := structFields(v.Type())
+	values := make([]reflect.Value, 0, len(fields))
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index, false)
+		if !ok {
+			continue
+		}
+		values = append(values, fv)
+		names = append(names, f.name)
+	}
+	e.encodeLen(0x80, 0xde, 0xdf, len(values))
+	for i, fv := range values {
+		e.encodeString(names[i])
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type decoder struct {
+	r *bytes.Reader
+}
+
+func (d *decoder) readN(n int) ([]byte, error) {
+	if n < 0 || n > d.r.Len() {
+		return nil, ErrInvalidFormat
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return nil, ErrInvalidFormat
+	}
+	return b, nil
+}
+
+func (d *decoder) readUint(size int) (uint64, error) {
+	b, err := d.readN(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// decode reads the next value as one of nil, bool, int64, uint64, float64, string, []byte,
+// []interface{} or map[string]interface{} (map[interface{}]interface{} for non-string keys).
+func (d *decoder) decode() (interface{}, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, ErrInvalidFormat
+	}
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.decodeMap(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.decodeArray(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.decodeString(int(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readUint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.readN(int(n))
+	case 0xca:
+		n, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.readUint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.readUint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		return n, nil
+	case 0xd0:
+		n, err := d.readUint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := d.readUint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := d.readUint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := d.readUint(8)
+		return int64(n), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readUint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeString(int(n))
+	case 0xdc, 0xdd:
+		n, err := d.readUint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(n))
+	case 0xde, 0xdf:
+		n, err := d.readUint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(n))
+	}
+	return nil, fmt.Errorf("%w: unknown code 0x%x", ErrInvalidFormat, c)
+}
+
+func (d *decoder) decodeString(n int) (interface{}, error) {
+	b, err := d.readN(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *decoder) decodeArray(n int) (interface{}, error) {
+	if n > d.r.Len() {
+		return nil, ErrInvalidFormat
+	}
+	arr := make([]interface{}, n)
+	for i := range arr {
+		x, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = x
+	}
+	return arr, nil
+}
+
+func (d *decoder) decodeMap(n int) (interface{}, error) {
+	if n > d.r.Len() {
+		return nil, ErrInvalidFormat
+	}
+	m := make(map[string]interface{}, n)
+	var other map[interface{}]interface{}
+	for i := 0; i < n; i++ {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := k.(string); ok && other == nil {
+			m[key] = v
+			continue
+		}
+		if other == nil {
+			other = make(map[interface{}]interface{}, n)
+			for mk, mv := range m {
+				other[mk] = mv
+			}
+		}
+		if k != nil && !reflect.TypeOf(k).Comparable() {
+			return nil, fmt.Errorf("%w: map key is not comparable", ErrInvalidFormat)
+		}
+		other[k] = v
+	}
+	if other != nil {
+		return other, nil
+	}
+	return m, nil
+}
+
+// assign stores the generic decoded value x into v, converting it to v's type.
+func assign(v reflect.Value, x interface{}) error {
+	if x == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return assign(v.Elem(), x)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			if v.IsNil() {
+				return fmt.Errorf("%w: %s", ErrUnsupportedType, v.Type())
+			}
+			return assign(v.Elem(), x)
+		}
+		v.Set(reflect.ValueOf(x))
+		return nil
+	}
+	switch val := x.(type) {
+	case bool:
+		if v.Kind() == reflect.Bool {
+			v.SetBool(val)
+			return nil
+		}
+	case int64:
+		return assignNumber(v, val < 0, val, uint64(val), float64(val))
+	case uint64:
+		return assignNumber(v, false, int64(val), val, float64(val))
+	case float64:
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(val)
+			return nil
+		}
+	case string:
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(val)
+			return nil
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes([]byte(val))
+			return nil
+		}
+	case []byte:
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(val))
+			return nil
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(val)
+			return nil
+		}
+	case []interface{}:
+		return assignArray(v, val)
+	case map[string]interface{}:
+		if v.Kind() == reflect.Struct {
+			return assignStruct(v, val)
+		}
+		generic := make(map[interface{}]interface{}, len(val))
+		for k, e := range val {
+			generic[k] = e
+		}
+		return assignMap(v, generic)
+	case map[interface{}]interface{}:
+		return assignMap(v, val)
+	}
+	return fmt.Errorf("%w: can't decode %T into %s", ErrUnsupportedType, x, v.Type())
+}
+
+// assignNumber stores an integer into v, i and u are the same number seen as signed and unsigned.
+func assignNumber(v reflect.Value, neg bool, i int64, u uint64, f float64) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if (!neg && u > math.MaxInt64) || v.OverflowInt(i) {
+			return fmt.Errorf("%w: %v overflows %s", ErrUnsupportedType, f, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if neg || v.OverflowUint(u) {
+			return fmt.Errorf("%w: %v overflows %s", ErrUnsupportedType, f, v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("%w: can't decode number into %s", ErrUnsupportedType, v.Type())
+	}
+	return nil
+}
+
+func assignArray(v reflect.Value, arr []interface{}) error {
+	switch v.Kind() {
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), len(arr), len(arr))
+		for i, x := range arr {
+			if err := assign(s.Index(i), x); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			var x interface{}
+			if i < len(arr) {
+				x = arr[i]
+			}
+			if err := assign(v.Index(i), x); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: can't decode array into %s", ErrUnsupportedType, v.Type())
+}
+
+func assignMap(v reflect.Value, m map[interface{}]interface{}) error {
+	if v.Kind() != reflect.Map {
+		return fmt.Errorf("%w: can't decode map into %s", ErrUnsupportedType, v.Type())
+	}
+	t := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(t, len(m)))
+	}
+	for k, e := range m {
+		key := reflect.New(t.Key()).Elem()
+		if err := assign(key, k); err != nil {
+			return err
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if err := assign(elem, e); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+	}
+	return nil
+}
+
+func assignStruct(v reflect.Value, m map[string]interface{}) error {
+	for _, f := range structFields(v.Type()) {
+		x, ok := m[f.name]
+		if !ok {
+			// fall back to case-insensitive match like encoding/json
+			for k, e := range m {
+				if strings.EqualFold(k, f.name) {
+					x, ok = e, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		fv, _ := fieldByIndex(v, f.index, true)
+		if err := assign(fv, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
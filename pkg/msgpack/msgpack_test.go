@@ -0,0 +1,90 @@
+package msgpack
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+type inner struct {
+	Name string
+}
+
+type sample struct {
+	inner
+	Bool    bool
+	Int     int
+	Int8    int8
+	Int64   int64
+	Uint16  uint16
+	Uint64  uint64
+	Float32 float32
+	Float64 float64
+	Bytes   []byte
+	Strings []string
+	Nil     []int
+	Map     map[string]int
+	Ptr     *inner
+	Renamed string `msgpack:"renamed"`
+	Skipped string `msgpack:"-"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := sample{
+		inner:   inner{Name: "proxy"},
+		Bool:    true,
+		Int:     -1 << 40,
+		Int8:    -33,
+		Int64:   math.MinInt64,
+		Uint16:  65535,
+		Uint64:  math.MaxUint64,
+		Float32: 1.5,
+		Float64: -2.25,
+		Bytes:   []byte{0, 1, 2},
+		Strings: []string{"", "a", string(make([]byte, 300))},
+		Map:     map[string]int{"a": 1, "b": -200},
+		Ptr:     &inner{Name: "ptr"},
+		Renamed: "renamed",
+		Skipped: "skipped",
+	}
+	data, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out sample
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Skipped = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch:\n%+v\n%+v", in, out)
+	}
+}
+
+func TestIntBoundaries(t *testing.T) {
+	for _, n := range []int64{0, 127, 128, -32, -33, 255, 256, -128, -129, 65535, 65536, -32768, -32769,
+		math.MaxInt32, math.MaxInt32 + 1, math.MinInt32, math.MinInt32 - 1, math.MaxInt64, math.MinInt64} {
+		data, err := Marshal(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out int64
+		if err := Unmarshal(data, &out); err != nil {
+			t.Fatalf("%d: %s", n, err)
+		}
+		if out != n {
+			t.Fatalf("got %d, want %d", out, n)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var out sample
+	if err := Unmarshal([]byte{0x81}, out); err != ErrNotPointer {
+		t.Fatalf("got %v, want ErrNotPointer", err)
+	}
+	// a map of one entry without the entry
+	if err := Unmarshal([]byte{0x81}, &out); err == nil {
+		t.Fatal("truncated data is decoded")
+	}
+}
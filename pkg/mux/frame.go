@@ -0,0 +1,72 @@
+package mux
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	protoVersion uint8 = 0
+
+	// headerSize is the size of frame header: version(1) type(1) flags(2) stream id(4) length(4)
+	headerSize = 12
+)
+
+// frame types
+const (
+	// typeData carries Length bytes of payload for the stream
+	typeData uint8 = iota
+	// typeWindowUpdate grows the send window of the stream by Length
+	typeWindowUpdate
+	// typePing is used for keepalive, answered by a ping with flagACK
+	typePing
+	// typeGoAway closes the whole session
+	typeGoAway
+)
+
+// frame flags
+const (
+	// flagSYN opens a new stream
+	flagSYN uint16 = 1 << iota
+	// flagACK acknowledges a ping
+	flagACK
+	// flagFIN half-closes the stream, no more data will be sent
+	flagFIN
+	// flagRST resets the stream immediately
+	flagRST
+)
+
+type header [headerSize]byte
+
+func (h header) Version() uint8 {
+	return h[0]
+}
+
+func (h header) MsgType() uint8 {
+	return h[1]
+}
+
+func (h header) Flags() uint16 {
+	return binary.BigEndian.Uint16(h[2:4])
+}
+
+func (h header) StreamID() uint32 {
+	return binary.BigEndian.Uint32(h[4:8])
+}
+
+func (h header) Length() uint32 {
+	return binary.BigEndian.Uint32(h[8:12])
+}
+
+func (h header) String() string {
+	return fmt.Sprintf("Vsn:%d Type:%d Flags:%d StreamID:%d Length:%d",
+		h.Version(), h.MsgType(), h.Flags(), h.StreamID(), h.Length())
+}
+
+func (h *header) encode(msgType uint8, flags uint16, streamID uint32, length uint32) {
+	h[0] = protoVersion
+	h[1] = msgType
+	binary.BigEndian.PutUint16(h[2:4], flags)
+	binary.BigEndian.PutUint32(h[4:8], streamID)
+	binary.BigEndian.PutUint32(h[8:12], length)
+}
@@ -0,0 +1,173 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// testPair returns the client and server sessions over a loopback tcp connection.
+func testPair(t *testing.T, config *Config) (*Session, *Session) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn, ok := <-accepted
+	if !ok {
+		t.Fatal("accept failed")
+	}
+	client, server := Client(conn, config), Server(serverConn, config)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestOpenAccept(t *testing.T) {
+	client, server := testPair(t, nil)
+	// larger than the window so that window updates are needed
+	payload := bytes.Repeat([]byte("breaker"), 100000)
+	go func() {
+		stream, err := server.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(stream, stream)
+		stream.Close()
+	}()
+	stream, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		stream.Write(payload)
+		stream.Close()
+	}()
+	got, err := ioutil.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("echo mismatch, got %d bytes, want %d", len(got), len(payload))
+	}
+	waitStreams(t, client, 0)
+	waitStreams(t, server, 0)
+}
+
+func TestHalfClose(t *testing.T) {
+	client, server := testPair(t, nil)
+	stream, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	// the client can't write after close, but still reads the reply
+	stream.Close()
+	if _, err := stream.Write([]byte("x")); err != ErrStreamClosed {
+		t.Fatalf("got %v, want ErrStreamClosed", err)
+	}
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(peer)
+	if err != nil || string(got) != "ping" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if _, err := peer.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	peer.Close()
+	got, err = ioutil.ReadAll(stream)
+	if err != nil || string(got) != "pong" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	waitStreams(t, client, 0)
+	waitStreams(t, server, 0)
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := testPair(t, nil)
+	stream, err := client.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.AcceptStream(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	stream.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := stream.Read(make([]byte, 1)); err != ErrStreamReset && err != ErrSessionClosed {
+		t.Fatalf("got %v, want the stream closed", err)
+	}
+	select {
+	case <-client.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("client session is not closed by go away")
+	}
+	if _, err := client.Open(); err != ErrSessionClosed {
+		t.Fatalf("got %v, want ErrSessionClosed", err)
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		// a dead peer reads everything and never answers
+		conn, err := l.Accept()
+		if err == nil {
+			io.Copy(ioutil.Discard, conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.KeepAliveInterval = 20 * time.Millisecond
+	config.KeepAliveTimeout = 100 * time.Millisecond
+	session := Client(conn, config)
+	select {
+	case <-session.CloseChan():
+	case <-time.After(2 * time.Second):
+		t.Fatal("session to a dead peer is not closed")
+	}
+	if _, err := session.Open(); err != ErrSessionClosed {
+		t.Fatalf("got %v, want ErrSessionClosed", err)
+	}
+}
+
+func waitStreams(t *testing.T, s *Session, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for s.NumStreams() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d streams, want %d", s.NumStreams(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
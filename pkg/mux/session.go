@@ -0,0 +1,346 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Version is the first byte of every frame, it lets the server tell
+// multiplexed connections apart from plain ones.
+const Version = protoVersion
+
+var (
+	ErrSessionClosed      = errors.New("mux: session closed")
+	ErrStreamClosed       = errors.New("mux: stream closed")
+	ErrStreamReset        = errors.New("mux: stream reset")
+	ErrTimeout            = timeoutError{}
+	ErrInvalidVersion     = errors.New("mux: invalid protocol version")
+	ErrRecvWindowExceeded = errors.New("mux: receive window exceeded")
+	ErrStreamsExhausted   = errors.New("mux: stream ids exhausted")
+	ErrKeepAliveTimeout   = errors.New("mux: keepalive timeout")
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "mux: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type Config struct {
+	// AcceptBacklog is the number of streams waiting to be accepted.
+	AcceptBacklog int
+	// MaxStreamWindowSize is the receive window of each stream,
+	// the peer can't send more than this before the data is read.
+	MaxStreamWindowSize uint32
+	// MaxFrameSize is the max payload of a data frame.
+	MaxFrameSize uint32
+	// KeepAliveInterval is the interval of ping, 0 disables keepalive.
+	KeepAliveInterval time.Duration
+	// KeepAliveTimeout closes the session if no frame, including the ack of ping,
+	// is received for it. It's checked with keepalive, 0 never closes the session.
+	KeepAliveTimeout time.Duration
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		AcceptBacklog:       256,
+		MaxStreamWindowSize: 256 * 1024,
+		MaxFrameSize:        16 * 1024,
+		KeepAliveInterval:   30 * time.Second,
+		KeepAliveTimeout:    90 * time.Second,
+	}
+}
+
+// Session multiplexes streams over a single connection.
+// Streams opened by the client side have odd ids, those opened by the server side have even ids.
+type Session struct {
+	// lastRecv is the unix nano time when the last frame is received,
+	// it's the first field to be aligned for atomic operations
+	lastRecv int64
+	// pinging is 1 while a ping is being written
+	pinging int32
+
+	conn   net.Conn
+	config *Config
+
+	nextStreamID uint32
+	streams      map[uint32]*Stream
+	streamLock   sync.Mutex
+
+	acceptCh  chan *Stream
+	writeLock sync.Mutex
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Client returns the session of the dialing side.
+func Client(conn net.Conn, config *Config) *Session {
+	return newSession(conn, config, 1)
+}
+
+// Server returns the session of the accepting side.
+func Server(conn net.Conn, config *Config) *Session {
+	return newSession(conn, config, 2)
+}
+
+func newSession(conn net.Conn, config *Config, firstID uint32) *Session {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	s := &Session{
+		conn:         conn,
+		config:       config,
+		nextStreamID: firstID,
+		streams:      make(map[uint32]*Stream),
+		acceptCh:     make(chan *Stream, config.AcceptBacklog),
+		closed:       make(chan struct{}),
+		lastRecv:     time.Now().UnixNano(),
+	}
+	go s.recvLoop()
+	if config.KeepAliveInterval > 0 {
+		go s.keepalive()
+	}
+	return s
+}
+
+// Open opens a new stream, the peer receives it from Accept.
+func (s *Session) Open() (*Stream, error) {
+	if s.IsClosed() {
+		return nil, ErrSessionClosed
+	}
+	s.streamLock.Lock()
+	id := s.nextStreamID
+	if id >= ^uint32(0)-1 {
+		s.streamLock.Unlock()
+		return nil, ErrStreamsExhausted
+	}
+	s.nextStreamID += 2
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.streamLock.Unlock()
+
+	// announce the stream with an empty window update
+	if err := s.writeFrame(typeWindowUpdate, flagSYN, id, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// AcceptStream waits for the next stream opened by the peer.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-s.acceptCh:
+		return stream, nil
+	case <-s.closed:
+		return nil, s.closeErr
+	}
+}
+
+// Accept implements net.Listener.
+func (s *Session) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+// Addr implements net.Listener.
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// NumStreams returns the number of opened streams.
+func (s *Session) NumStreams() int {
+	s.streamLock.Lock()
+	defer s.streamLock.Unlock()
+	return len(s.streams)
+}
+
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseChan is closed when the session is closed.
+func (s *Session) CloseChan() <-chan struct{} {
+	return s.closed
+}
+
+// Close closes the session and all its streams.
+func (s *Session) Close() error {
+	s.closeWithErr(ErrSessionClosed, true)
+	return nil
+}
+
+func (s *Session) closeWithErr(err error, goAway bool) {
+	s.closeOnce.Do(func() {
+		if goAway {
+			_ = s.writeFrame(typeGoAway, 0, 0, nil)
+		}
+		s.closeErr = err
+		close(s.closed)
+		s.conn.Close()
+		s.streamLock.Lock()
+		for id, stream := range s.streams {
+			stream.forceClose()
+			delete(s.streams, id)
+		}
+		s.streamLock.Unlock()
+	})
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.streamLock.Lock()
+	delete(s.streams, id)
+	s.streamLock.Unlock()
+}
+
+// writeFrame writes header and payload atomically, length of header is the payload size
+// for data frame, or given by the payload-less frame itself.
+func (s *Session) writeFrame(msgType uint8, flags uint16, id uint32, payload []byte) error {
+	return s.writeFrameLen(msgType, flags, id, uint32(len(payload)), payload)
+}
+
+func (s *Session) writeFrameLen(msgType uint8, flags uint16, id uint32, length uint32, payload []byte) error {
+	var hdr header
+	hdr.encode(msgType, flags, id, length)
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if _, err := s.conn.Write(hdr[:]); err != nil {
+		go s.closeWithErr(err, false)
+		return err
+	}
+	if len(payload) > 0 {
+		if _, err := s.conn.Write(payload); err != nil {
+			go s.closeWithErr(err, false)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) keepalive() {
+	ticker := time.NewTicker(s.config.KeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&s.lastRecv)))
+			if s.config.KeepAliveTimeout > 0 && idle > s.config.KeepAliveTimeout {
+				log.Errorf("mux: nothing received from [%s] for %s, close the session", s.conn.RemoteAddr(), idle)
+				s.closeWithErr(ErrKeepAliveTimeout, false)
+				return
+			}
+			// the write may block on a dead peer, the idle check must go on meanwhile
+			if atomic.CompareAndSwapInt32(&s.pinging, 0, 1) {
+				go func() {
+					_ = s.writeFrameLen(typePing, flagSYN, 0, 0, nil)
+					atomic.StoreInt32(&s.pinging, 0)
+				}()
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *Session) recvLoop() {
+	var hdr header
+	for {
+		if _, err := io.ReadFull(s.conn, hdr[:]); err != nil {
+			s.closeWithErr(err, false)
+			return
+		}
+		atomic.StoreInt64(&s.lastRecv, time.Now().UnixNano())
+		if hdr.Version() != protoVersion {
+			s.closeWithErr(ErrInvalidVersion, true)
+			return
+		}
+		var err error
+		switch hdr.MsgType() {
+		case typeData, typeWindowUpdate:
+			err = s.handleStreamFrame(hdr)
+		case typePing:
+			if hdr.Flags()&flagSYN != 0 {
+				err = s.writeFrameLen(typePing, flagACK, 0, hdr.Length(), nil)
+			}
+		case typeGoAway:
+			err = ErrSessionClosed
+		default:
+			err = fmt.Errorf("mux: invalid frame %s", hdr)
+		}
+		if err != nil {
+			s.closeWithErr(err, false)
+			return
+		}
+	}
+}
+
+func (s *Session) handleStreamFrame(hdr header) error {
+	id := hdr.StreamID()
+	flags := hdr.Flags()
+
+	s.streamLock.Lock()
+	stream, ok := s.streams[id]
+	if !ok && flags&flagSYN != 0 {
+		stream = newStream(s, id)
+		s.streams[id] = stream
+		select {
+		case s.acceptCh <- stream:
+		default:
+			// backlog is full, refuse the stream
+			delete(s.streams, id)
+			s.streamLock.Unlock()
+			log.Errorf("mux: accept backlog full, reset stream %d", id)
+			_ = s.writeFrame(typeWindowUpdate, flagRST, id, nil)
+			return s.discard(hdr)
+		}
+	}
+	s.streamLock.Unlock()
+
+	if stream == nil {
+		// the stream may be closed locally already, drop its data
+		return s.discard(hdr)
+	}
+	if hdr.MsgType() == typeWindowUpdate {
+		stream.incrSendWindow(hdr.Length())
+	} else if hdr.Length() > 0 {
+		if err := stream.readData(s.conn, hdr.Length()); err != nil {
+			return err
+		}
+	}
+	if flags&flagFIN != 0 {
+		stream.remoteClose()
+	}
+	if flags&flagRST != 0 {
+		stream.reset()
+	}
+	return nil
+}
+
+func (s *Session) discard(hdr header) error {
+	if hdr.MsgType() != typeData || hdr.Length() == 0 {
+		return nil
+	}
+	_, err := io.CopyN(io.Discard, s.conn, int64(hdr.Length()))
+	return err
+}
@@ -0,0 +1,258 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream is a virtual connection inside Session, it implements net.Conn.
+type Stream struct {
+	id      uint32
+	session *Session
+
+	lock sync.Mutex
+	// recvBuf holds received data not read yet
+	recvBuf *bytes.Buffer
+	// recvPending is the size of data read but not yet granted back to the peer
+	recvPending uint32
+	sendWindow  uint32
+
+	// localClosed means FIN was sent, remoteClosed means FIN was received
+	localClosed  bool
+	remoteClosed bool
+	resetted     bool
+
+	readNotify  chan struct{}
+	writeNotify chan struct{}
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		id:          id,
+		session:     session,
+		recvBuf:     bytes.NewBuffer(nil),
+		sendWindow:  session.config.MaxStreamWindowSize,
+		readNotify:  make(chan struct{}, 1),
+		writeNotify: make(chan struct{}, 1),
+	}
+}
+
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Stream) Read(b []byte) (n int, err error) {
+	for {
+		s.lock.Lock()
+		if s.recvBuf.Len() > 0 {
+			n, _ = s.recvBuf.Read(b)
+			s.recvPending += uint32(n)
+			var delta uint32
+			// grant the window back once half of it is consumed
+			if s.recvPending >= s.session.config.MaxStreamWindowSize/2 {
+				delta = s.recvPending
+				s.recvPending = 0
+			}
+			closed := s.localClosed && s.remoteClosed
+			s.lock.Unlock()
+			if delta > 0 && !closed {
+				_ = s.session.writeFrameLen(typeWindowUpdate, 0, s.id, delta, nil)
+			}
+			return n, nil
+		}
+		if s.resetted {
+			s.lock.Unlock()
+			return 0, ErrStreamReset
+		}
+		if s.remoteClosed {
+			s.lock.Unlock()
+			return 0, io.EOF
+		}
+		deadline := s.readDeadline
+		s.lock.Unlock()
+
+		if err := s.wait(s.readNotify, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (s *Stream) Write(b []byte) (n int, err error) {
+	for n < len(b) {
+		s.lock.Lock()
+		if s.resetted {
+			s.lock.Unlock()
+			return n, ErrStreamReset
+		}
+		if s.localClosed {
+			s.lock.Unlock()
+			return n, ErrStreamClosed
+		}
+		if s.sendWindow == 0 {
+			deadline := s.writeDeadline
+			s.lock.Unlock()
+			if err := s.wait(s.writeNotify, deadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+		size := uint32(len(b) - n)
+		if size > s.sendWindow {
+			size = s.sendWindow
+		}
+		if size > s.session.config.MaxFrameSize {
+			size = s.session.config.MaxFrameSize
+		}
+		s.sendWindow -= size
+		s.lock.Unlock()
+
+		if err := s.session.writeFrame(typeData, 0, s.id, b[n:n+int(size)]); err != nil {
+			return n, err
+		}
+		n += int(size)
+	}
+	return n, nil
+}
+
+// wait blocks until ch is notified, the deadline passes or the session is closed.
+func (s *Stream) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return ErrTimeout
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return ErrTimeout
+	case <-s.session.closed:
+		// wake up once more so that buffered data and EOF are still returned
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.recvBuf.Len() > 0 || s.remoteClosed || s.resetted {
+			return nil
+		}
+		return ErrSessionClosed
+	}
+}
+
+// Close half-closes the stream, the peer reads EOF after all data sent.
+func (s *Stream) Close() error {
+	s.lock.Lock()
+	if s.localClosed || s.resetted {
+		s.lock.Unlock()
+		return nil
+	}
+	s.localClosed = true
+	done := s.remoteClosed
+	s.lock.Unlock()
+
+	notify(s.writeNotify)
+	if done {
+		s.session.removeStream(s.id)
+	}
+	if s.session.IsClosed() {
+		return nil
+	}
+	return s.session.writeFrame(typeWindowUpdate, flagFIN, s.id, nil)
+}
+
+func (s *Stream) readData(r io.Reader, length uint32) error {
+	if length > s.session.config.MaxStreamWindowSize {
+		return ErrRecvWindowExceeded
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if uint32(s.recvBuf.Len())+length > s.session.config.MaxStreamWindowSize {
+		return ErrRecvWindowExceeded
+	}
+	s.recvBuf.Write(buf)
+	notify(s.readNotify)
+	return nil
+}
+
+func (s *Stream) incrSendWindow(delta uint32) {
+	s.lock.Lock()
+	s.sendWindow += delta
+	s.lock.Unlock()
+	notify(s.writeNotify)
+}
+
+func (s *Stream) remoteClose() {
+	s.lock.Lock()
+	s.remoteClosed = true
+	done := s.localClosed
+	s.lock.Unlock()
+	notify(s.readNotify)
+	if done {
+		s.session.removeStream(s.id)
+	}
+}
+
+func (s *Stream) reset() {
+	s.forceClose()
+	s.session.removeStream(s.id)
+}
+
+// forceClose marks the stream unusable without telling the peer.
+func (s *Stream) forceClose() {
+	s.lock.Lock()
+	s.resetted = true
+	s.lock.Unlock()
+	notify(s.readNotify)
+	notify(s.writeNotify)
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return s.session.LocalAddr()
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.session.RemoteAddr()
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	if err := s.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return s.SetWriteDeadline(t)
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.lock.Lock()
+	s.readDeadline = t
+	s.lock.Unlock()
+	notify(s.readNotify)
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.lock.Lock()
+	s.writeDeadline = t
+	s.lock.Unlock()
+	notify(s.writeNotify)
+	return nil
+}
@@ -0,0 +1,157 @@
+package netio
+
+import (
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// ErrNoEncryptionKey is returned if encryption is requested without a token,
+// the key would be a constant known by everyone.
+var ErrNoEncryptionKey = errors.New("encryption requires a token")
+
+// DeriveKey derives the aes-128 key of tunnel encryption from the auth token.
+func DeriveKey(token string) []byte {
+	sum := sha256.Sum256([]byte("breaker:" + token))
+	return sum[:aes.BlockSize]
+}
+
+// WrapTunnelConn applies encryption and compression to the work connection,
+// both ends of the tunnel must be wrapped with the same options. Compression is
+// deflate of the standard library instead of snappy, so that no dependency is added.
+func WrapTunnelConn(conn net.Conn, useEncryption, useCompression bool, token string) (net.Conn, error) {
+	var rwc io.ReadWriteCloser = conn
+	if useEncryption {
+		if token == "" {
+			return nil, ErrNoEncryptionKey
+		}
+		var err error
+		if rwc, err = WithEncryption(rwc, DeriveKey(token)); err != nil {
+			return nil, err
+		}
+	}
+	if useCompression {
+		rwc = WithCompression(rwc)
+	}
+	if rwc == io.ReadWriteCloser(conn) {
+		return conn, nil
+	}
+	return WrapConn(conn, rwc), nil
+}
+
+type cryptoReadWriteCloser struct {
+	rwc   io.ReadWriteCloser
+	block cipher.Block
+
+	readOnce  sync.Once
+	reader    io.Reader
+	readErr   error
+	writeOnce sync.Once
+	writer    io.Writer
+	writeErr  error
+}
+
+// WithEncryption encrypts the stream by aes-128-cfb, each direction starts with a random iv.
+func WithEncryption(rwc io.ReadWriteCloser, key []byte) (io.ReadWriteCloser, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &cryptoReadWriteCloser{rwc: rwc, block: block}, nil
+}
+
+func (c *cryptoReadWriteCloser) Read(p []byte) (int, error) {
+	c.readOnce.Do(func() {
+		iv := make([]byte, aes.BlockSize)
+		if _, c.readErr = io.ReadFull(c.rwc, iv); c.readErr != nil {
+			return
+		}
+		c.reader = &cipher.StreamReader{S: cipher.NewCFBDecrypter(c.block, iv), R: c.rwc}
+	})
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+	return c.reader.Read(p)
+}
+
+func (c *cryptoReadWriteCloser) Write(p []byte) (int, error) {
+	c.writeOnce.Do(func() {
+		iv := make([]byte, aes.BlockSize)
+		if _, c.writeErr = rand.Read(iv); c.writeErr != nil {
+			return
+		}
+		if _, c.writeErr = c.rwc.Write(iv); c.writeErr != nil {
+			return
+		}
+		c.writer = &cipher.StreamWriter{S: cipher.NewCFBEncrypter(c.block, iv), W: c.rwc}
+	})
+	if c.writeErr != nil {
+		return 0, c.writeErr
+	}
+	return c.writer.Write(p)
+}
+
+func (c *cryptoReadWriteCloser) Close() error {
+	return c.rwc.Close()
+}
+
+type compressReadWriteCloser struct {
+	rwc    io.ReadWriteCloser
+	reader io.ReadCloser
+	writer *flate.Writer
+}
+
+// WithCompression compresses the stream by deflate, every write is flushed
+// so that interactive traffic is not delayed.
+func WithCompression(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	writer, _ := flate.NewWriter(rwc, flate.BestSpeed)
+	return &compressReadWriteCloser{
+		rwc:    rwc,
+		reader: flate.NewReader(rwc),
+		writer: writer,
+	}
+}
+
+func (c *compressReadWriteCloser) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *compressReadWriteCloser) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.writer.Flush()
+}
+
+func (c *compressReadWriteCloser) Close() error {
+	return c.rwc.Close()
+}
+
+// wrappedConn reads and writes through rwc, the rest of net.Conn is served by the raw conn.
+type wrappedConn struct {
+	net.Conn
+	rwc io.ReadWriteCloser
+}
+
+func WrapConn(conn net.Conn, rwc io.ReadWriteCloser) net.Conn {
+	return &wrappedConn{Conn: conn, rwc: rwc}
+}
+
+func (c *wrappedConn) Read(p []byte) (int, error) {
+	return c.rwc.Read(p)
+}
+
+func (c *wrappedConn) Write(p []byte) (int, error) {
+	return c.rwc.Write(p)
+}
+
+func (c *wrappedConn) Close() error {
+	return c.rwc.Close()
+}
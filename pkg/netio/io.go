@@ -7,23 +7,27 @@ import (
 	"sync"
 )
 
-func StartTunnel(src, dest net.Conn) {
+// StartTunnel copies between src and dest until either is closed, it returns
+// the bytes copied from src to dest and from dest to src.
+func StartTunnel(src, dest net.Conn) (int64, int64) {
 	var wait sync.WaitGroup
+	var srcToDest, destToSrc int64
 
-	pipe := func(to io.ReadWriteCloser, from io.ReadWriteCloser) {
+	pipe := func(to io.ReadWriteCloser, from io.ReadWriteCloser, n *int64) {
 		defer func() {
 			if e := recover(); e != nil {
-				log.Error("StartTunnel panic error: %v", e)
+				log.Errorf("StartTunnel panic error: %v", e)
 			}
 		}()
 		defer to.Close()
 		defer from.Close()
 		defer wait.Done()
-		io.Copy(to, from)
+		*n, _ = io.Copy(to, from)
 	}
 
 	wait.Add(2)
-	go pipe(src, dest)
-	go pipe(dest, src)
+	go pipe(src, dest, &destToSrc)
+	go pipe(dest, src, &srcToDest)
 	wait.Wait()
+	return srcToDest, destToSrc
 }
@@ -0,0 +1,118 @@
+package netio
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+// throughputLogInterval is the least interval of logging the throughput of a limiter
+const throughputLogInterval = 10 * time.Second
+
+// Limiter is a token bucket of bytes refilled at the rate per second, it holds
+// at most one second of tokens. The throughput is logged while bytes are passing.
+type Limiter struct {
+	Name   string
+	rate   float64
+	tokens float64
+	last   time.Time
+	// passed is the number of bytes since logged
+	passed int64
+	logged time.Time
+	lock   sync.Mutex
+}
+
+func NewLimiter(name string, bytesPerSecond int64) *Limiter {
+	now := time.Now()
+	return &Limiter{
+		Name:   name,
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   now,
+		logged: now,
+	}
+}
+
+// WaitN takes n tokens, it blocks until the bucket is refilled if it runs out of tokens.
+func (l *Limiter) WaitN(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	// tokens may go negative, the debt is paid by waiting
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	switch elapsed := now.Sub(l.logged); {
+	case elapsed >= 2*throughputLogInterval:
+		// it's been idle, the throughput is measured from now on
+		l.passed = 0
+		l.logged = now
+	case elapsed >= throughputLogInterval:
+		log.Infof("bandwidth limiter:[%s] throughput:%s/s, limit:%s/s", l.Name,
+			FormatBytes(int64(float64(l.passed)/elapsed.Seconds())), FormatBytes(int64(l.rate)))
+		l.passed = 0
+		l.logged = now
+	}
+	l.passed += int64(n)
+	l.lock.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// FormatBytes formats n as B, KB, MB or GB in units of 1024.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	f := float64(n)
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		f /= unit
+		if f < unit || suffix == "GB" {
+			return fmt.Sprintf("%.1f%s", f, suffix)
+		}
+	}
+	return ""
+}
+
+// limitConn waits for the limiters after reading and before writing.
+type limitConn struct {
+	net.Conn
+	readLimiter  *Limiter
+	writeLimiter *Limiter
+}
+
+// NewLimitConn limits the bytes read from and written to conn, a nil limiter is unlimited.
+func NewLimitConn(conn net.Conn, readLimiter, writeLimiter *Limiter) net.Conn {
+	if readLimiter == nil && writeLimiter == nil {
+		return conn
+	}
+	return &limitConn{
+		Conn:         conn,
+		readLimiter:  readLimiter,
+		writeLimiter: writeLimiter,
+	}
+}
+
+func (c *limitConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.readLimiter.WaitN(n)
+	return n, err
+}
+
+func (c *limitConn) Write(b []byte) (int, error) {
+	c.writeLimiter.WaitN(len(b))
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,28 @@
+package netio
+
+import (
+	"bufio"
+	"net"
+)
+
+// PeekConn allows looking ahead the incoming bytes without consuming them,
+// the peeked bytes are returned again by Read.
+type PeekConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func NewPeekConn(conn net.Conn) *PeekConn {
+	return &PeekConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+}
+
+func (c *PeekConn) Peek(n int) ([]byte, error) {
+	return c.reader.Peek(n)
+}
+
+func (c *PeekConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,66 @@
+package netio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// versions of the PROXY protocol header
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+// proxyProtocolV2Sig starts every v2 header
+var proxyProtocolV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyProtocolHeader builds the PROXY protocol header telling the local service the
+// source and destination of the user connection. The addresses are "ip:port", an
+// UNKNOWN (v1) or LOCAL (v2) header is built if they're empty or invalid.
+func ProxyProtocolHeader(version string, srcAddr, dstAddr string) ([]byte, error) {
+	src, srcErr := net.ResolveTCPAddr("tcp", srcAddr)
+	dst, dstErr := net.ResolveTCPAddr("tcp", dstAddr)
+	// the families of the addresses must match
+	known := srcErr == nil && dstErr == nil && srcAddr != "" && dstAddr != "" &&
+		(src.IP.To4() == nil) == (dst.IP.To4() == nil)
+	switch version {
+	case ProxyProtocolV1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		proto := "TCP4"
+		if src.IP.To4() == nil {
+			proto = "TCP6"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %s %s\r\n", proto, src.IP, dst.IP,
+			strconv.Itoa(src.Port), strconv.Itoa(dst.Port))), nil
+	case ProxyProtocolV2:
+		buf := &bytes.Buffer{}
+		buf.Write(proxyProtocolV2Sig)
+		if !known {
+			// LOCAL command, the receiver uses the real addresses of the connection
+			buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+			return buf.Bytes(), nil
+		}
+		// PROXY command over TCP of ipv4 or ipv6
+		buf.WriteByte(0x21)
+		srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+		if srcIP == nil {
+			srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+			buf.WriteByte(0x21)
+		} else {
+			buf.WriteByte(0x11)
+		}
+		binary.Write(buf, binary.BigEndian, uint16(len(srcIP)*2+4))
+		buf.Write(srcIP)
+		buf.Write(dstIP)
+		binary.Write(buf, binary.BigEndian, uint16(src.Port))
+		binary.Write(buf, binary.BigEndian, uint16(dst.Port))
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("invalid proxy protocol version:%s", version)
+	}
+}
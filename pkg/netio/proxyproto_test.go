@@ -0,0 +1,53 @@
+package netio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	sig := "\r\n\r\n\x00\r\nQUIT\n"
+	tests := []struct {
+		name             string
+		version          string
+		srcAddr, dstAddr string
+		want             string
+	}{
+		{"v1 tcp4", ProxyProtocolV1, "192.168.1.2:51234", "10.0.0.1:443",
+			"PROXY TCP4 192.168.1.2 10.0.0.1 51234 443\r\n"},
+		{"v1 tcp6", ProxyProtocolV1, "[2001:db8::1]:51234", "[::1]:443",
+			"PROXY TCP6 2001:db8::1 ::1 51234 443\r\n"},
+		{"v1 unknown source", ProxyProtocolV1, "", "10.0.0.1:443",
+			"PROXY UNKNOWN\r\n"},
+		{"v1 mixed families", ProxyProtocolV1, "192.168.1.2:51234", "[::1]:443",
+			"PROXY UNKNOWN\r\n"},
+		{"v2 tcp4", ProxyProtocolV2, "192.168.1.2:51234", "10.0.0.1:443",
+			sig + "\x21\x11\x00\x0c" +
+				"\xc0\xa8\x01\x02" + "\x0a\x00\x00\x01" + "\xc8\x22" + "\x01\xbb"},
+		{"v2 tcp6", ProxyProtocolV2, "[2001:db8::1]:51234", "[::1]:443",
+			sig + "\x21\x21\x00\x24" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+				"\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+				"\xc8\x22" + "\x01\xbb"},
+		{"v2 local", ProxyProtocolV2, "", "",
+			sig + "\x20\x00\x00\x00"},
+		{"v2 invalid address", ProxyProtocolV2, "not an address", "10.0.0.1:443",
+			sig + "\x20\x00\x00\x00"},
+	}
+	for _, tt := range tests {
+		got, err := ProxyProtocolHeader(tt.version, tt.srcAddr, tt.dstAddr)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, []byte(tt.want)) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProxyProtocolHeaderInvalidVersion(t *testing.T) {
+	if _, err := ProxyProtocolHeader("v3", "192.168.1.2:51234", "10.0.0.1:443"); err == nil {
+		t.Fatal("no error with an invalid version")
+	}
+}
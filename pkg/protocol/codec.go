@@ -0,0 +1,23 @@
+package protocol
+
+// names of the codecs that can be used to encode the Command payload
+const (
+	CodecJson    = "json"
+	CodecMsgpack = "msgpack"
+	CodecBinary  = "binary"
+)
+
+// SupportedCodecs lists every codec name known by this release, in order of preference.
+var SupportedCodecs = []string{CodecBinary, CodecMsgpack, CodecJson}
+
+func IsSupportedCodec(name string) bool {
+	return contains(SupportedCodecs, name)
+}
+
+// names of the compressions that can be applied to the tunneled payload
+const (
+	CompressionFlate = "flate"
+)
+
+// SupportedCompressions lists every compression name known by this release.
+var SupportedCompressions = []string{CompressionFlate}
@@ -6,4 +6,19 @@ type Command interface {
 
 type Resp struct {
 	Error string
+	// Code tells the kind of Error, see the Code* constants.
+	Code int
+}
+
+// error codes of Resp
+const (
+	CodeOK = iota
+	CodeAuthFailed
+	CodeIncompatible
+)
+
+// Packet is a framed message on the wire, Payload holds the Command encoded by a codec.
+type Packet struct {
+	Type    byte
+	Payload []byte
 }
@@ -86,37 +86,55 @@ func readMsg(c io.Reader) (typeByte byte, buffer []byte, err error) {
 	return
 }
 
-func ReadMsg(c io.Reader) (msg Command, err error) {
+// ReadPacket reads a framed packet from c, the payload is left encoded.
+func ReadPacket(c io.Reader) (*Packet, error) {
 	typeByte, buffer, err := readMsg(c)
 	if err != nil {
-		return
+		return nil, err
 	}
+	return &Packet{Type: typeByte, Payload: buffer}, nil
+}
+
+// PacketToBytes frames the packet as type byte, payload length and payload.
+func PacketToBytes(p *Packet) []byte {
+	buffer := bytes.NewBuffer(nil)
+	buffer.WriteByte(p.Type)
+	_ = binary.Write(buffer, binary.BigEndian, int64(len(p.Payload)))
+	buffer.Write(p.Payload)
+	return buffer.Bytes()
+}
+
+// NewCommand returns a zero value of the Command registered with typeByte.
+func NewCommand(typeByte byte) (Command, error) {
 	t, ok := MsgManager.typeMap[typeByte]
 	if !ok {
-		err = ErrMsgType
-		return
+		return nil, ErrMsgType
 	}
 	//指针类型获取真正type需要调用Elem
 	if t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
-	msg = reflect.New(t).Interface().(Command)
-	err = json.Unmarshal(buffer, &msg)
+	return reflect.New(t).Interface().(Command), nil
+}
+
+func ReadMsg(c io.Reader) (msg Command, err error) {
+	p, err := ReadPacket(c)
+	if err != nil {
+		return
+	}
+	msg, err = NewCommand(p.Type)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(p.Payload, &msg)
 	return
 }
 func CmdToBytes(msg Command) ([]byte, error) {
-	typeByte := msg.Type()
-
 	content, err := json.Marshal(msg)
 	if err != nil {
 		return nil, err
 	}
-
-	buffer := bytes.NewBuffer(nil)
-	buffer.WriteByte(typeByte)
-	_ = binary.Write(buffer, binary.BigEndian, int64(len(content)))
-	buffer.Write(content)
-	return buffer.Bytes(), nil
+	return PacketToBytes(&Packet{Type: msg.Type(), Payload: content}), nil
 }
 func WriteMsg(c io.Writer, msg Command) (err error) {
 	buf, err := CmdToBytes(msg)
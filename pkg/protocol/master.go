@@ -1,6 +1,13 @@
 package protocol
 
 type NewMaster struct {
+	Capability
+	// Timestamp is the unix time when the PrivilegeKey is generated.
+	Timestamp int64
+	// PrivilegeKey is HMAC(auth token, Timestamp and Nonce).
+	PrivilegeKey string
+	// Nonce is random for every login, so that logins of the same second have different keys.
+	Nonce string
 }
 
 func (n *NewMaster) Type() byte {
@@ -10,6 +17,8 @@ func (n *NewMaster) Type() byte {
 type NewMasterResp struct {
 	Resp
 	SessionId string
+	// Capability is the negotiated result, Codecs holds exactly the codec chosen for the master session.
+	Capability
 }
 
 func (n *NewMasterResp) Type() byte {
@@ -1,9 +1,64 @@
 package protocol
 
+// types of proxies
+const (
+	ProxyTypeTCP = "tcp"
+	ProxyTypeUDP = "udp"
+	// ProxyTypeHTTP is routed by the vhost http port of the portal instead of RemotePort
+	ProxyTypeHTTP = "http"
+	// ProxyTypeHTTPS is routed by the server name of tls on the vhost https port of the portal
+	ProxyTypeHTTPS = "https"
+	// ProxyTypeSTCP opens no port on the portal, only visitors with the secret key can reach it
+	ProxyTypeSTCP = "stcp"
+)
+
+// strategies of load balanced groups
+const (
+	GroupStrategyRoundRobin = "round_robin"
+	GroupStrategyLeastConn  = "least_conn"
+)
+
+// sides enforcing the bandwidth limit of proxies
+const (
+	BandwidthLimitModeBridge = "bridge"
+	BandwidthLimitModePortal = "portal"
+)
+
 type NewProxy struct {
 	RemotePort int
 	ProxyName  string
 	TraceId    string
+	// UseEncryption and UseCompression wrap the work connections of the proxy,
+	// the key of encryption is derived from the auth token.
+	UseEncryption  bool
+	UseCompression bool
+	// ProxyType is one of the proxy types, empty means tcp.
+	ProxyType string
+	// CustomDomains, SubDomain and Locations route the requests of http and https proxies,
+	// SubDomain is joined with the subdomain_host of the portal.
+	CustomDomains []string
+	SubDomain     string
+	Locations     []string
+	// Sk is the secret key of stcp proxies, visitors sign their connections with it.
+	Sk string
+	// Group, GroupKey and GroupStrategy share the RemotePort of tcp proxies among
+	// bridges, user connections are load balanced between the members.
+	Group         string
+	GroupKey      string
+	GroupStrategy string
+	// PoolCount is the number of work connections the bridge dials in advance.
+	PoolCount int
+	// BandwidthLimit is the bytes per second of each direction enforced by the portal,
+	// 0 means unlimited or enforced by the bridge.
+	BandwidthLimit int64
+	// MaxConnections, MaxConnectionsPerIP and MaxConnectionRatePerIP limit the user
+	// connections of tcp proxies, 0 means unlimited.
+	MaxConnections         int
+	MaxConnectionsPerIP    int
+	MaxConnectionRatePerIP int
+	// AllowIPs and DenyIPs are CIDR lists checking the source ips of user connections.
+	AllowIPs []string
+	DenyIPs  []string
 }
 
 func (n *NewProxy) Type() byte {
@@ -13,6 +68,12 @@ func (n *NewProxy) Type() byte {
 type NewProxyResp struct {
 	Resp
 	ProxyName string
+	// RemotePort is the port the proxy listens on, it's allocated by the portal
+	// if NewProxy.RemotePort is 0.
+	RemotePort int
+	// PoolCount is NewProxy.PoolCount capped by the max_pool_count of the portal,
+	// 0 means the portal doesn't cap it.
+	PoolCount int
 }
 
 func (n *NewProxyResp) Type() byte {
@@ -1,18 +1,21 @@
 package protocol
 
 const (
-	TypeCloseProxy     = '1'
-	TypeNewProxy       = '2'
-	TypeNewWorkCtl     = '3'
-	TypeNewMaster      = '4'
-	TypeReqWorkCtl     = '5'
-	TypeCloseProxyResp = '6'
-	TypeNewProxyResp   = '7'
-	TypeNewWorkCtlResp = '8'
-	TypeNewMasterResp  = '9'
-	TypeReqWorkCtlResp = '0'
-	TypePing           = 'a'
-	TypePong           = 'b'
+	TypeCloseProxy         = '1'
+	TypeNewProxy           = '2'
+	TypeNewWorkCtl         = '3'
+	TypeNewMaster          = '4'
+	TypeReqWorkCtl         = '5'
+	TypeCloseProxyResp     = '6'
+	TypeNewProxyResp       = '7'
+	TypeNewWorkCtlResp     = '8'
+	TypeNewMasterResp      = '9'
+	TypeReqWorkCtlResp     = '0'
+	TypePing               = 'a'
+	TypePong               = 'b'
+	TypeNewVisitorConn     = 'c'
+	TypeNewVisitorConnResp = 'd'
+	TypeStartWorkConn      = 'e'
 )
 
 func init() {
@@ -28,4 +31,7 @@ func init() {
 	RegisterCommand(&NewWorkCtlResp{})
 	RegisterCommand(&Ping{})
 	RegisterCommand(&Pong{})
+	RegisterCommand(&NewVisitorConn{})
+	RegisterCommand(&NewVisitorConnResp{})
+	RegisterCommand(&StartWorkConn{})
 }
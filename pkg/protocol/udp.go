@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrUDPPacketTooLarge = errors.New("udp packet too large")
+
+// UDPPacket is a datagram carried by the work connection of udp proxies,
+// it's framed as [addr length uint8][addr][content length uint16][content].
+type UDPPacket struct {
+	// RemoteAddr is the address of the user who sent the datagram to the portal,
+	// replies are sent back to it.
+	RemoteAddr string
+	Content    []byte
+}
+
+func WriteUDPPacket(w io.Writer, p *UDPPacket) error {
+	if len(p.RemoteAddr) > 0xff || len(p.Content) > 0xffff {
+		return ErrUDPPacketTooLarge
+	}
+	buf := make([]byte, 0, 3+len(p.RemoteAddr)+len(p.Content))
+	buf = append(buf, byte(len(p.RemoteAddr)))
+	buf = append(buf, p.RemoteAddr...)
+	buf = append(buf, byte(len(p.Content)>>8), byte(len(p.Content)))
+	buf = append(buf, p.Content...)
+	// one frame one write, the datagram is not split by packet based writers
+	_, err := w.Write(buf)
+	return err
+}
+
+func ReadUDPPacket(r io.Reader) (*UDPPacket, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:1]); err != nil {
+		return nil, err
+	}
+	addr := make([]byte, size[0])
+	if _, err := io.ReadFull(r, addr); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	content := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return &UDPPacket{RemoteAddr: string(addr), Content: content}, nil
+}
@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// countWriter counts the writes, every frame must be written at once.
+type countWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(b)
+}
+
+func TestUDPPacketRoundTrip(t *testing.T) {
+	packets := []*UDPPacket{
+		{RemoteAddr: "1.2.3.4:5678", Content: []byte("hello")},
+		{RemoteAddr: "[2001:db8::1]:53", Content: []byte{}},
+		{RemoteAddr: "", Content: []byte("no address")},
+		{RemoteAddr: "1.2.3.4:5678", Content: bytes.Repeat([]byte{0xff}, 0xffff)},
+	}
+	w := &countWriter{}
+	for _, p := range packets {
+		if err := WriteUDPPacket(w, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.writes != len(packets) {
+		t.Fatalf("%d writes of %d packets", w.writes, len(packets))
+	}
+	for _, want := range packets {
+		got, err := ReadUDPPacket(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.RemoteAddr != want.RemoteAddr || !bytes.Equal(got.Content, want.Content) {
+			t.Fatalf("got %s %d bytes, want %s %d bytes", got.RemoteAddr, len(got.Content),
+				want.RemoteAddr, len(want.Content))
+		}
+	}
+	if _, err := ReadUDPPacket(w); err != io.EOF {
+		t.Fatalf("got %v at the end, want EOF", err)
+	}
+}
+
+func TestUDPPacketTooLarge(t *testing.T) {
+	w := &countWriter{}
+	for _, p := range []*UDPPacket{
+		{RemoteAddr: string(bytes.Repeat([]byte("a"), 0x100))},
+		{Content: make([]byte, 0x10000)},
+	} {
+		if err := WriteUDPPacket(w, p); !errors.Is(err, ErrUDPPacketTooLarge) {
+			t.Fatalf("got %v, want ErrUDPPacketTooLarge", err)
+		}
+	}
+	if w.writes != 0 {
+		t.Fatal("too large packet is written")
+	}
+}
+
+func TestUDPPacketTruncated(t *testing.T) {
+	w := &countWriter{}
+	if err := WriteUDPPacket(w, &UDPPacket{RemoteAddr: "1.2.3.4:5678", Content: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	frame := w.Bytes()
+	for _, n := range []int{1, 5, len(frame) - 1} {
+		if _, err := ReadUDPPacket(bytes.NewReader(frame[:n])); err == nil {
+			t.Fatalf("%d bytes of %d are read as a packet", n, len(frame))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// Version is the protocol version spoken by this release.
+	Version = 1
+	// MinCompatibleVersion is the oldest protocol version still understood by this release.
+	MinCompatibleVersion = 1
+)
+
+// features that can be negotiated during login
+const (
+	FeatureHeartbeat = "heartbeat"
+	// FeatureStartWorkConn lets the portal send StartWorkConn on a pooled work connection
+	// when it's taken by a user, the bridge dials the local service after that.
+	FeatureStartWorkConn = "start_work_conn"
+)
+
+// SupportedFeatures lists every feature implemented by this release.
+var SupportedFeatures = []string{FeatureHeartbeat, FeatureStartWorkConn}
+
+var ErrNoCommonCodec = errors.New("no common codec")
+
+// Capability describes what one side of the master connection is able to speak,
+// it's exchanged in NewMaster/NewMasterResp so that both sides downgrade to the common set.
+type Capability struct {
+	Version int
+	// Codecs are codec names ordered by preference.
+	Codecs       []string
+	Compressions []string
+	Mux          bool
+	Features     []string
+}
+
+// LocalCapability returns everything this release supports.
+func LocalCapability() Capability {
+	return Capability{
+		Version:      Version,
+		Codecs:       append([]string(nil), SupportedCodecs...),
+		Compressions: append([]string(nil), SupportedCompressions...),
+		Mux:          false,
+		Features:     append([]string(nil), SupportedFeatures...),
+	}
+}
+
+// PreferCodec moves codec to the front of Codecs.
+func (c Capability) PreferCodec(codec string) Capability {
+	codecs := []string{codec}
+	for _, name := range c.Codecs {
+		if name != codec {
+			codecs = append(codecs, name)
+		}
+	}
+	c.Codecs = codecs
+	return c
+}
+
+func (c Capability) HasFeature(feature string) bool {
+	return contains(c.Features, feature)
+}
+
+func (c Capability) HasCompression(compression string) bool {
+	return contains(c.Compressions, compression)
+}
+
+// CheckVersion returns error if the remote protocol version can't be understood.
+func CheckVersion(remote int) error {
+	if remote < MinCompatibleVersion {
+		return fmt.Errorf("incompatible protocol version %d, at least %d is required", remote, MinCompatibleVersion)
+	}
+	return nil
+}
+
+// Negotiate returns the capability both local and remote support.
+// The codec follows the remote's preference, the version is the lower one of both sides.
+func Negotiate(local, remote Capability) (Capability, error) {
+	if err := CheckVersion(remote.Version); err != nil {
+		return Capability{}, err
+	}
+	agreed := Capability{
+		Version:      local.Version,
+		Compressions: intersect(remote.Compressions, local.Compressions),
+		Mux:          local.Mux && remote.Mux,
+		Features:     intersect(remote.Features, local.Features),
+	}
+	if remote.Version < agreed.Version {
+		agreed.Version = remote.Version
+	}
+	for _, codec := range remote.Codecs {
+		if contains(local.Codecs, codec) {
+			agreed.Codecs = []string{codec}
+			break
+		}
+	}
+	if len(agreed.Codecs) == 0 {
+		return Capability{}, fmt.Errorf("%w, local:%v remote:%v", ErrNoCommonCodec, local.Codecs, remote.Codecs)
+	}
+	return agreed, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// intersect returns elements of a which are also in b, in order of a.
+func intersect(a, b []string) []string {
+	res := make([]string, 0, len(a))
+	for _, v := range a {
+		if contains(b, v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,25 @@
+package protocol
+
+// NewVisitorConn is sent by a visitor bridge on a new connection, the portal pairs
+// the connection with a work connection of the stcp proxy named ProxyName.
+type NewVisitorConn struct {
+	ProxyName string
+	// SignKey is the HMAC of Timestamp and Nonce keyed by the secret key of the proxy.
+	SignKey   string
+	Timestamp int64
+	// Nonce is random for every connection, a replayed SignKey is rejected by it.
+	Nonce string
+}
+
+func (n *NewVisitorConn) Type() byte {
+	return TypeNewVisitorConn
+}
+
+type NewVisitorConnResp struct {
+	Resp
+	ProxyName string
+}
+
+func (n *NewVisitorConnResp) Type() byte {
+	return TypeNewVisitorConnResp
+}
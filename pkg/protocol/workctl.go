@@ -3,6 +3,11 @@ package protocol
 type NewWorkCtl struct {
 	TraceID   string
 	ProxyName string
+	// Timestamp, Nonce and PrivilegeKey sign the work connection like NewMaster,
+	// so that knowing the session id isn't enough to attach work connections.
+	Timestamp    int64
+	Nonce        string
+	PrivilegeKey string
 }
 
 func (n *NewWorkCtl) Type() byte {
@@ -35,3 +40,16 @@ type ReqWorkCtlResp struct {
 func (n *ReqWorkCtlResp) Type() byte {
 	return TypeReqWorkCtlResp
 }
+
+// StartWorkConn is written to a pooled work connection when it's taken by a user.
+type StartWorkConn struct {
+	ProxyName string
+	// SrcAddr and DstAddr are the "ip:port" of the user and the portal of the user
+	// connection, they're empty if the proxy doesn't serve user connections directly.
+	SrcAddr string
+	DstAddr string
+}
+
+func (n *StartWorkConn) Type() byte {
+	return TypeStartWorkConn
+}
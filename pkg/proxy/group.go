@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"breaker/pkg/netio"
+	"breaker/pkg/protocol"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+)
+
+var ErrGroupNoMember = errors.New("no member of the group is available")
+
+// GroupConfig is used by tcp proxies to join a load balanced group.
+type GroupConfig struct {
+	Name string
+	// Key must be the same among the members, so that nobody else can join.
+	Key string
+	// Strategy is protocol.GroupStrategyRoundRobin or protocol.GroupStrategyLeastConn.
+	Strategy string
+}
+
+// TcpGroup shares one port among tcp proxies of several bridges.
+type TcpGroup struct {
+	GroupConfig
+	addr     string
+	listener net.Listener
+	members  []*TcpProxy
+	// next is the index of the member to pick by round robin
+	next int
+	lock sync.Mutex
+}
+
+// pick returns the members in the order they should be tried.
+func (g *TcpGroup) pick() []*TcpProxy {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	n := len(g.members)
+	if n == 0 {
+		return nil
+	}
+	start := 0
+	if g.Strategy == protocol.GroupStrategyLeastConn {
+		for i, member := range g.members {
+			if member.ActiveConns() < g.members[start].ActiveConns() {
+				start = i
+			}
+		}
+	} else {
+		start = g.next % n
+		g.next = start + 1
+	}
+	candidates := make([]*TcpProxy, 0, n)
+	for i := 0; i < n; i++ {
+		candidates = append(candidates, g.members[(start+i)%n])
+	}
+	return candidates
+}
+
+// handleConn tunnels the user connection by the picked member, the next
+// member is tried if it has no work connection.
+func (g *TcpGroup) handleConn(userconn net.Conn) {
+	for _, member := range g.pick() {
+		// a rejection by the ip lists or the limits isn't retried on other members,
+		// otherwise the limits would be multiplied by the number of members
+		if !member.allowedConn(userconn) {
+			userconn.Close()
+			return
+		}
+		release, ok := member.acquireConn(userconn)
+		if !ok {
+			return
+		}
+		workConn, err := member.GetUserWorkConn(userconn)
+		if err != nil {
+			release()
+			log.Errorf("group:[%s] proxy:[%s] can not get work conn with err:[%+v]", g.Name, member.Name, err)
+			continue
+		}
+		netio.StartTunnel(workConn, userconn)
+		release()
+		return
+	}
+	log.Errorf("group:[%s] %s", g.Name, ErrGroupNoMember)
+	userconn.Close()
+}
+
+// TcpGroupManager keeps the groups by name, a group listens until its last member leaves.
+type TcpGroupManager struct {
+	groups map[string]*TcpGroup
+	lock   sync.Mutex
+}
+
+func NewTcpGroupManager() *TcpGroupManager {
+	return &TcpGroupManager{
+		groups: make(map[string]*TcpGroup),
+	}
+}
+
+// Join adds the proxy to the group, the group starts listening on addr if it's the first member.
+func (m *TcpGroupManager) Join(pxy *TcpProxy, addr string, conf GroupConfig) (*TcpGroup, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	group, ok := m.groups[conf.Name]
+	if !ok {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		group = &TcpGroup{
+			GroupConfig: conf,
+			addr:        addr,
+			listener:    listener,
+		}
+		m.groups[conf.Name] = group
+		go func() {
+			acceptLoop(listener, group.handleConn)
+			log.Infof("group:[%s] exist", conf.Name)
+		}()
+	} else {
+		if subtle.ConstantTimeCompare([]byte(group.Key), []byte(conf.Key)) != 1 {
+			return nil, fmt.Errorf("group:[%s] invalid group key", conf.Name)
+		}
+		if group.addr != addr {
+			return nil, fmt.Errorf("group:[%s] listens on:[%s] instead of:[%s]", conf.Name, group.addr, addr)
+		}
+		if group.Strategy != conf.Strategy {
+			return nil, fmt.Errorf("group:[%s] strategy is:[%s] instead of:[%s]", conf.Name, group.Strategy, conf.Strategy)
+		}
+	}
+	group.lock.Lock()
+	group.members = append(group.members, pxy)
+	group.lock.Unlock()
+	log.Infof("proxy:[%s] join group:[%s]", pxy.Name, conf.Name)
+	return group, nil
+}
+
+// Leave removes the proxy from the group, the port is closed if no member is left.
+func (m *TcpGroupManager) Leave(group *TcpGroup, pxy *TcpProxy) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	group.lock.Lock()
+	for i, member := range group.members {
+		if member == pxy {
+			group.members = append(group.members[:i:i], group.members[i+1:]...)
+			break
+		}
+	}
+	empty := len(group.members) == 0
+	group.lock.Unlock()
+	log.Infof("proxy:[%s] leave group:[%s]", pxy.Name, group.Name)
+	if empty && m.groups[group.Name] == group {
+		delete(m.groups, group.Name)
+		group.listener.Close()
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"breaker/pkg/protocol"
+)
+
+func testMember(name string, groups *TcpGroupManager, conf GroupConfig) *TcpProxy {
+	return NewGroupTcpProxy(NewBaseProxy(name, nil, 1), groups, conf)
+}
+
+func names(members []*TcpProxy) string {
+	s := ""
+	for _, member := range members {
+		s += member.Name
+	}
+	return s
+}
+
+func TestGroupJoin(t *testing.T) {
+	groups := NewTcpGroupManager()
+	conf := GroupConfig{Name: "web", Key: "k", Strategy: protocol.GroupStrategyRoundRobin}
+	a := testMember("a", groups, conf)
+	if err := a.Serve("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		conf GroupConfig
+		addr string
+	}{
+		{"wrong key", GroupConfig{Name: "web", Key: "x", Strategy: conf.Strategy}, "127.0.0.1:0"},
+		{"other addr", conf, "127.0.0.1:1"},
+		{"other strategy", GroupConfig{Name: "web", Key: "k", Strategy: protocol.GroupStrategyLeastConn}, "127.0.0.1:0"},
+	}
+	for _, tt := range tests {
+		if err := testMember(tt.name, groups, tt.conf).Serve(tt.addr); err == nil {
+			t.Errorf("%s: joined the group", tt.name)
+		}
+	}
+	b := testMember("b", groups, conf)
+	if err := b.Serve("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if a.group != b.group || names(a.group.pick()) != "ab" {
+		t.Fatal("members don't share the group")
+	}
+
+	addr := a.group.listener.Addr().String()
+	a.Close()
+	if names(b.group.pick()) != "b" {
+		t.Fatal("closed member is still picked")
+	}
+	// the port is kept until the last member leaves
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	b.Close()
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("the port is open after every member left")
+	}
+	if _, ok := groups.groups["web"]; ok {
+		t.Fatal("empty group is kept")
+	}
+}
+
+func TestGroupPickRoundRobin(t *testing.T) {
+	g := &TcpGroup{GroupConfig: GroupConfig{Strategy: protocol.GroupStrategyRoundRobin}}
+	if g.pick() != nil {
+		t.Fatal("picked from an empty group")
+	}
+	g.members = []*TcpProxy{
+		NewTcpProxy(NewBaseProxy("a", nil, 1)),
+		NewTcpProxy(NewBaseProxy("b", nil, 1)),
+		NewTcpProxy(NewBaseProxy("c", nil, 1)),
+	}
+	for _, want := range []string{"abc", "bca", "cab", "abc"} {
+		if got := names(g.pick()); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+}
+
+func TestGroupPickLeastConn(t *testing.T) {
+	g := &TcpGroup{GroupConfig: GroupConfig{Strategy: protocol.GroupStrategyLeastConn}}
+	active := map[string]int64{"a": 2, "b": 0, "c": 1}
+	for _, name := range []string{"a", "b", "c"} {
+		member := NewTcpProxy(NewBaseProxy(name, nil, 1))
+		atomic.StoreInt64(&member.stats.activeConns, active[name])
+		g.members = append(g.members, member)
+	}
+	for i := 0; i < 2; i++ {
+		if got := names(g.pick()); got != "bca" {
+			t.Fatalf("got %s, want bca", got)
+		}
+	}
+	// ties go to the first member
+	atomic.StoreInt64(&g.members[0].stats.activeConns, 0)
+	if got := names(g.pick()); got != "abc" {
+		t.Fatalf("got %s, want abc", got)
+	}
+}
+
+func TestGroupRejectNotRetried(t *testing.T) {
+	full := NewConnLimiter(1, 0, 0, nil)
+	if _, err := full.Acquire(addr("10.0.0.1", 1)); err != nil {
+		t.Fatal(err)
+	}
+	a := NewTcpProxy(NewBaseProxy("a", nil, 1))
+	a.ConnLimiter = full
+	// b has no session, it would panic if the rejected conn were retried on it
+	b := NewTcpProxy(NewBaseProxy("b", nil, 1))
+	g := &TcpGroup{
+		GroupConfig: GroupConfig{Strategy: protocol.GroupStrategyRoundRobin},
+		members:     []*TcpProxy{a, b},
+	}
+	user, portal := net.Pipe()
+	defer user.Close()
+	done := make(chan struct{})
+	go func() {
+		g.handleConn(portal)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handleConn doesn't return")
+	}
+	if _, err := user.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("user conn isn't closed: %v", err)
+	}
+	if a.stats.RejectedConns() != 1 {
+		t.Fatalf("rejected conns of a: %d", a.stats.RejectedConns())
+	}
+}
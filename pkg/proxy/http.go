@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"breaker/pkg/vhost"
+	"sync"
+)
+
+// HttpProxy doesn't listen by itself, its domains and locations are routed
+// by the shared vhost http port of the portal.
+type HttpProxy struct {
+	*BaseProxy
+	Domains   []string
+	Locations []string
+	muxer     *vhost.HTTPMuxer
+	// routes are the registered pairs of domain and location
+	routes    [][2]string
+	closeOnce sync.Once
+}
+
+func NewHttpProxy(base *BaseProxy, muxer *vhost.HTTPMuxer, domains []string, locations []string) *HttpProxy {
+	if len(locations) == 0 {
+		locations = []string{"/"}
+	}
+	return &HttpProxy{
+		BaseProxy: base,
+		Domains:   domains,
+		Locations: locations,
+		muxer:     muxer,
+	}
+}
+
+// Serve registers the routes of the proxy, addr is ignored.
+func (h *HttpProxy) Serve(addr string) error {
+	for _, domain := range h.Domains {
+		for _, location := range h.Locations {
+			if err := h.muxer.Register(domain, location, h.GetWorkConn); err != nil {
+				h.unregister()
+				return err
+			}
+			h.routes = append(h.routes, [2]string{domain, location})
+		}
+	}
+	return nil
+}
+
+// unregister removes the routes registered by Serve.
+func (h *HttpProxy) unregister() {
+	for _, route := range h.routes {
+		h.muxer.Unregister(route[0], route[1])
+	}
+	h.routes = nil
+}
+
+func (h *HttpProxy) Info() Info {
+	info := h.BaseProxy.Info()
+	info.Domains = h.Domains
+	return info
+}
+
+func (h *HttpProxy) Close() {
+	h.closeOnce.Do(func() {
+		h.unregister()
+		h.closeWorkConns()
+	})
+}
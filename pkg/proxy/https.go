@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"breaker/pkg/vhost"
+	"sync"
+)
+
+// HttpsProxy is routed by the server name of the tls ClientHello on the shared
+// vhost https port of the portal, the tls stream is forwarded as it is.
+type HttpsProxy struct {
+	*BaseProxy
+	Domains []string
+	muxer   *vhost.HTTPSMuxer
+	// registered are the domains registered by Serve
+	registered []string
+	closeOnce  sync.Once
+}
+
+func NewHttpsProxy(base *BaseProxy, muxer *vhost.HTTPSMuxer, domains []string) *HttpsProxy {
+	return &HttpsProxy{
+		BaseProxy: base,
+		Domains:   domains,
+		muxer:     muxer,
+	}
+}
+
+// Serve registers the domains of the proxy, addr is ignored.
+func (h *HttpsProxy) Serve(addr string) error {
+	for _, domain := range h.Domains {
+		if err := h.muxer.Register(domain, h.GetWorkConn); err != nil {
+			h.unregister()
+			return err
+		}
+		h.registered = append(h.registered, domain)
+	}
+	return nil
+}
+
+func (h *HttpsProxy) unregister() {
+	for _, domain := range h.registered {
+		h.muxer.Unregister(domain)
+	}
+	h.registered = nil
+}
+
+func (h *HttpsProxy) Info() Info {
+	info := h.BaseProxy.Info()
+	info.Domains = h.Domains
+	return info
+}
+
+func (h *HttpsProxy) Close() {
+	h.closeOnce.Do(func() {
+		h.unregister()
+		h.closeWorkConns()
+	})
+}
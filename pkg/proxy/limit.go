@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTooManyConns      = errors.New("too many connections")
+	ErrTooManyConnsPerIP = errors.New("too many connections of the ip")
+	ErrConnRateExceeded  = errors.New("connection rate of the ip exceeded")
+)
+
+// ipSweepInterval is the interval of forgetting the idle ips
+const ipSweepInterval = 10 * time.Second
+
+// ConnLimiter rejects user connections over the limits, a limit of 0 is unlimited.
+// The limits of the parent, e.g. the ceiling of the portal, are checked as well.
+type ConnLimiter struct {
+	MaxConns int
+	// MaxConnsPerIP is the max concurrent connections of one source ip.
+	MaxConnsPerIP int
+	// MaxRatePerIP is the max new connections of one source ip per second.
+	MaxRatePerIP int
+	parent       *ConnLimiter
+	active       int
+	ips          map[string]*ipConns
+	swept        time.Time
+	lock         sync.Mutex
+}
+
+type ipConns struct {
+	active int
+	// window is the start of the second counted by accepted
+	window   time.Time
+	accepted int
+}
+
+func NewConnLimiter(maxConns, maxConnsPerIP, maxRatePerIP int, parent *ConnLimiter) *ConnLimiter {
+	return &ConnLimiter{
+		MaxConns:      maxConns,
+		MaxConnsPerIP: maxConnsPerIP,
+		MaxRatePerIP:  maxRatePerIP,
+		parent:        parent,
+		ips:           make(map[string]*ipConns),
+		swept:         time.Now(),
+	}
+}
+
+// Acquire takes a slot for the connection from addr, release must be called once
+// the connection is closed. A nil limiter accepts every connection.
+func (l *ConnLimiter) Acquire(addr net.Addr) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if err := l.acquire(ip); err != nil {
+		return nil, err
+	}
+	parentRelease, err := l.parent.Acquire(addr)
+	if err != nil {
+		l.release(ip)
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			parentRelease()
+			l.release(ip)
+		})
+	}, nil
+}
+
+func (l *ConnLimiter) acquire(ip string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	if l.MaxConns > 0 && l.active >= l.MaxConns {
+		return ErrTooManyConns
+	}
+	if l.MaxConnsPerIP == 0 && l.MaxRatePerIP == 0 {
+		l.active++
+		return nil
+	}
+	conns, ok := l.ips[ip]
+	if !ok {
+		conns = &ipConns{window: now}
+		l.ips[ip] = conns
+	}
+	if l.MaxConnsPerIP > 0 && conns.active >= l.MaxConnsPerIP {
+		return ErrTooManyConnsPerIP
+	}
+	if now.Sub(conns.window) >= time.Second {
+		conns.window = now
+		conns.accepted = 0
+	}
+	if l.MaxRatePerIP > 0 && conns.accepted >= l.MaxRatePerIP {
+		return ErrConnRateExceeded
+	}
+	conns.accepted++
+	conns.active++
+	l.active++
+	return nil
+}
+
+func (l *ConnLimiter) release(ip string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.active--
+	if conns, ok := l.ips[ip]; ok {
+		conns.active--
+	}
+}
+
+// sweep forgets the ips without connections in the last second.
+func (l *ConnLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < ipSweepInterval {
+		return
+	}
+	l.swept = now
+	for ip, conns := range l.ips {
+		if conns.active == 0 && now.Sub(conns.window) >= time.Second {
+			delete(l.ips, ip)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func addr(ip string, port int) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+// mustAcquire takes a slot and fails the test if it's rejected.
+func mustAcquire(t *testing.T, l *ConnLimiter, a net.Addr) func() {
+	t.Helper()
+	release, err := l.Acquire(a)
+	if err != nil {
+		t.Fatalf("acquire %s: %s", a, err)
+	}
+	return release
+}
+
+func expectReject(t *testing.T, l *ConnLimiter, a net.Addr, want error) {
+	t.Helper()
+	if _, err := l.Acquire(a); !errors.Is(err, want) {
+		t.Fatalf("acquire %s: got %v, want %v", a, err, want)
+	}
+}
+
+func TestConnLimiterMaxConns(t *testing.T) {
+	l := NewConnLimiter(2, 0, 0, nil)
+	release := mustAcquire(t, l, addr("10.0.0.1", 1))
+	mustAcquire(t, l, addr("10.0.0.2", 1))
+	expectReject(t, l, addr("10.0.0.3", 1), ErrTooManyConns)
+	release()
+	// releasing twice doesn't free another slot
+	release()
+	mustAcquire(t, l, addr("10.0.0.3", 1))
+	expectReject(t, l, addr("10.0.0.4", 1), ErrTooManyConns)
+}
+
+func TestConnLimiterMaxConnsPerIP(t *testing.T) {
+	l := NewConnLimiter(0, 1, 0, nil)
+	release := mustAcquire(t, l, addr("10.0.0.1", 1))
+	// the port doesn't matter, conns are counted by ip
+	expectReject(t, l, addr("10.0.0.1", 2), ErrTooManyConnsPerIP)
+	mustAcquire(t, l, addr("10.0.0.2", 1))
+	release()
+	mustAcquire(t, l, addr("10.0.0.1", 2))
+}
+
+func TestConnLimiterRatePerIP(t *testing.T) {
+	l := NewConnLimiter(0, 0, 2, nil)
+	for i := 0; i < 2; i++ {
+		mustAcquire(t, l, addr("10.0.0.1", i))()
+	}
+	// released conns still count in the same second
+	expectReject(t, l, addr("10.0.0.1", 3), ErrConnRateExceeded)
+	mustAcquire(t, l, addr("10.0.0.2", 1))
+	// the next second starts a new window
+	l.lock.Lock()
+	l.ips["10.0.0.1"].window = time.Now().Add(-time.Second)
+	l.lock.Unlock()
+	mustAcquire(t, l, addr("10.0.0.1", 4))
+}
+
+func TestConnLimiterParent(t *testing.T) {
+	portal := NewConnLimiter(2, 0, 0, nil)
+	a := NewConnLimiter(1, 0, 0, portal)
+	b := NewConnLimiter(0, 0, 0, portal)
+	releaseA := mustAcquire(t, a, addr("10.0.0.1", 1))
+	expectReject(t, a, addr("10.0.0.1", 2), ErrTooManyConns)
+	releaseB := mustAcquire(t, b, addr("10.0.0.2", 1))
+	// the ceiling of the parent is shared by the children
+	expectReject(t, b, addr("10.0.0.2", 2), ErrTooManyConns)
+	b.lock.Lock()
+	active := b.active
+	b.lock.Unlock()
+	if active != 1 {
+		t.Fatalf("rejected by the parent, the child keeps %d active conns", active)
+	}
+	releaseA()
+	mustAcquire(t, b, addr("10.0.0.2", 3))
+	releaseB()
+	portal.lock.Lock()
+	active = portal.active
+	portal.lock.Unlock()
+	if active != 1 {
+		t.Fatalf("the parent has %d active conns, want 1", active)
+	}
+}
+
+func TestConnLimiterNil(t *testing.T) {
+	var l *ConnLimiter
+	release, err := l.Acquire(addr("10.0.0.1", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -6,42 +6,85 @@ import (
 	"sync"
 )
 
+// proxyKey identifies a proxy, names are only unique inside one session.
+type proxyKey struct {
+	sessid string
+	name   string
+}
+
 type ProxyManager struct {
 	//对用户访问的代理
-	RunningProxy map[string]*TcpProxy
+	RunningProxy map[proxyKey]Proxy
 
 	proxyLock sync.RWMutex
 }
 
-func (p *ProxyManager) AddProxy(sessid string, t *TcpProxy) error {
+func NewProxyManager() *ProxyManager {
+	return &ProxyManager{
+		RunningProxy: make(map[proxyKey]Proxy),
+	}
+}
+
+func (p *ProxyManager) AddProxy(sessid string, t Proxy) error {
 	p.proxyLock.Lock()
 	defer p.proxyLock.Unlock()
-	if _, ok := p.RunningProxy[t.Name]; ok {
-		log.Error("proxy already exist!")
+	key := proxyKey{sessid: sessid, name: t.GetName()}
+	if _, ok := p.RunningProxy[key]; ok {
+		log.Errorf("proxy:[%s] already exist!", t.GetName())
 		return errors.New("proxy already exist")
 	}
 
-	p.RunningProxy[sessid] = t
+	p.RunningProxy[key] = t
 	return nil
 }
 
-func (p *ProxyManager) DeleteProxy(sessid string) error {
+func (p *ProxyManager) DeleteProxy(sessid string, name string) error {
 	p.proxyLock.Lock()
 	defer p.proxyLock.Unlock()
-	pxy, ok := p.RunningProxy[sessid]
+	key := proxyKey{sessid: sessid, name: name}
+	pxy, ok := p.RunningProxy[key]
 	if !ok {
-		return errors.New("pxy:" + sessid + " is not ready")
+		return errors.New("pxy:" + name + " is not ready")
 	}
 	pxy.Close()
-	delete(p.RunningProxy, sessid)
+	delete(p.RunningProxy, key)
 	return nil
 }
-func (p *ProxyManager) GetProxy(sessid string) (*TcpProxy, bool) {
+
+// DeleteSessionProxies closes all proxies of the session, returns their names.
+func (p *ProxyManager) DeleteSessionProxies(sessid string) []string {
+	p.proxyLock.Lock()
+	defer p.proxyLock.Unlock()
+	var names []string
+	for key, pxy := range p.RunningProxy {
+		if key.sessid != sessid {
+			continue
+		}
+		pxy.Close()
+		delete(p.RunningProxy, key)
+		names = append(names, key.name)
+	}
+	return names
+}
+
+func (p *ProxyManager) GetProxy(sessid string, name string) (Proxy, bool) {
 	p.proxyLock.RLock()
 	defer p.proxyLock.RUnlock()
-	if pxy, ok := p.RunningProxy[sessid]; ok {
+	if pxy, ok := p.RunningProxy[proxyKey{sessid: sessid, name: name}]; ok {
 		return pxy, ok
 	}
 
 	return nil, false
 }
+
+// Range calls f for every running proxy until f returns false, f must not
+// add or delete proxies.
+func (p *ProxyManager) Range(f func(sessid string, pxy Proxy) bool) {
+	p.proxyLock.RLock()
+	defer p.proxyLock.RUnlock()
+	for key, pxy := range p.RunningProxy {
+		if !f(key.sessid, pxy) {
+			return
+		}
+	}
+}
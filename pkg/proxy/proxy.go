@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"breaker/pkg/breaker"
+	"breaker/pkg/ip"
+	"breaker/pkg/netio"
+	"breaker/pkg/protocol"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrProxyClosed      = errors.New("proxy closed")
+	ErrWorkConnPoolFull = errors.New("work connection pool is full")
+)
+
+// defaultPoolSize is the least capacity of the work connection pool
+const defaultPoolSize = 10
+
+// Proxy accepts users on the portal and forwards them to the bridge through work connections.
+type Proxy interface {
+	GetName() string
+	// Serve starts listening on addr, it returns once the listener is ready.
+	Serve(addr string) error
+	// PutWorkConn stores the work connection dialed by the bridge.
+	PutWorkConn(conn net.Conn) error
+	// Info returns the description and the statistics of the proxy.
+	Info() Info
+	Stats() *Stats
+	Close()
+}
+
+// Info describes a running proxy.
+type Info struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	RemotePort  int      `json:"remote_port,omitempty"`
+	Domains     []string `json:"domains,omitempty"`
+	ActiveConns int64    `json:"active_conns"`
+	TotalConns  int64    `json:"total_conns"`
+	// RejectedConns are rejected by the limits of connections.
+	RejectedConns int64 `json:"rejected_conns"`
+	// DeniedConns are denied by the ip lists.
+	DeniedConns int64 `json:"denied_conns"`
+	BytesIn     int64 `json:"bytes_in"`
+	BytesOut    int64 `json:"bytes_out"`
+}
+
+// BaseProxy manages the work connections, it's embedded by every proxy type.
+type BaseProxy struct {
+	Name string
+	// Type and RemotePort are requested by the bridge, they're only used to describe the proxy.
+	Type       string
+	RemotePort int
+	// UseEncryption and UseCompression wrap the work connections, same as the bridge does.
+	UseEncryption  bool
+	UseCompression bool
+	// Token derives the key of encryption.
+	Token string
+	// StartWorkConn sends StartWorkConn on the work connection before it's used,
+	// so that the bridge can pool work connections without dialing the local service.
+	StartWorkConn bool
+	// ConnLimiter rejects user connections over the limits, it's unlimited if it's nil.
+	ConnLimiter *ConnLimiter
+	// ACLs check the source ips of user connections, e.g. the lists of the portal and of the proxy.
+	ACLs        []*ip.ACL
+	WorkingChan chan net.Conn
+	// session is the master session of the bridge, work connections are requested through it
+	session breaker.Session
+	stats   *Stats
+	// limiters are pairs of limiters of bytes sent by users and bytes sent back to users
+	limiters  [][2]*netio.Limiter
+	closed    bool
+	closeLock sync.RWMutex
+}
+
+// NewBaseProxy creates the proxy with a pool holding at least poolCount work connections.
+func NewBaseProxy(name string, session breaker.Session, poolCount int) *BaseProxy {
+	if poolCount < defaultPoolSize {
+		poolCount = defaultPoolSize
+	}
+	return &BaseProxy{
+		Name:        name,
+		session:     session,
+		stats:       newStats(),
+		WorkingChan: make(chan net.Conn, poolCount),
+	}
+}
+
+func (b *BaseProxy) GetName() string {
+	return b.Name
+}
+
+// LimitBandwidth limits the work connections taken after it, in limits the bytes
+// sent by users and out limits the bytes sent back to users.
+func (b *BaseProxy) LimitBandwidth(in, out *netio.Limiter) {
+	b.limiters = append(b.limiters, [2]*netio.Limiter{in, out})
+}
+
+// allowedConn checks the source ip of the user connection by the ACLs, denied
+// connections are counted and logged.
+func (b *BaseProxy) allowedConn(userconn net.Conn) bool {
+	for _, acl := range b.ACLs {
+		if !acl.AllowedAddr(userconn.RemoteAddr()) {
+			b.stats.deny()
+			log.Warnf("proxy:[%s] deny user:[%s] by ip lists", b.Name, userconn.RemoteAddr())
+			return false
+		}
+	}
+	return true
+}
+
+// acquireConn takes a slot of the limits for the user connection, the connection
+// is closed if it's rejected.
+func (b *BaseProxy) acquireConn(userconn net.Conn) (release func(), ok bool) {
+	release, err := b.ConnLimiter.Acquire(userconn.RemoteAddr())
+	if err != nil {
+		b.stats.reject()
+		log.Warnf("proxy:[%s] reject user:[%s]: %s", b.Name, userconn.RemoteAddr(), err)
+		userconn.Close()
+		return nil, false
+	}
+	return release, true
+}
+
+// Stats returns the statistics of the user connections.
+func (b *BaseProxy) Stats() *Stats {
+	return b.stats
+}
+
+// ActiveConns returns the number of user connections being tunneled.
+func (b *BaseProxy) ActiveConns() int64 {
+	return b.stats.ActiveConns()
+}
+
+func (b *BaseProxy) Info() Info {
+	return Info{
+		Name:          b.Name,
+		Type:          b.Type,
+		RemotePort:    b.RemotePort,
+		ActiveConns:   b.stats.ActiveConns(),
+		TotalConns:    b.stats.TotalConns(),
+		RejectedConns: b.stats.RejectedConns(),
+		DeniedConns:   b.stats.DeniedConns(),
+		BytesIn:       b.stats.BytesIn(),
+		BytesOut:      b.stats.BytesOut(),
+	}
+}
+
+func (b *BaseProxy) PutWorkConn(conn net.Conn) error {
+	b.closeLock.RLock()
+	defer b.closeLock.RUnlock()
+	if b.closed {
+		return ErrProxyClosed
+	}
+	select {
+	case b.WorkingChan <- conn:
+		return nil
+	default:
+		return ErrWorkConnPoolFull
+	}
+}
+
+// GetWorkConn takes a work connection wrapped by encryption and compression,
+// a new one is requested from the bridge at the same time. The connection is
+// counted by the stats until it's closed.
+func (b *BaseProxy) GetWorkConn() (net.Conn, error) {
+	return b.getWorkConn(&protocol.StartWorkConn{ProxyName: b.Name})
+}
+
+// GetUserWorkConn takes a work connection for the user connection, the addresses of
+// the user are passed to the bridge for the PROXY protocol header.
+func (b *BaseProxy) GetUserWorkConn(userconn net.Conn) (net.Conn, error) {
+	return b.getWorkConn(&protocol.StartWorkConn{
+		ProxyName: b.Name,
+		SrcAddr:   userconn.RemoteAddr().String(),
+		DstAddr:   userconn.LocalAddr().String(),
+	})
+}
+
+func (b *BaseProxy) getWorkConn(startMsg *protocol.StartWorkConn) (net.Conn, error) {
+	start := time.Now()
+	defer func() {
+		b.stats.workConnWait.Observe(time.Since(start).Seconds())
+	}()
+	timeout := time.After(time.Duration(5) * time.Second)
+	for {
+		// get a work connection from the chan
+		select {
+		case workConn, ok := <-b.WorkingChan:
+			if !ok {
+				return nil, ErrProxyClosed
+			}
+			log.Infof("proxy:[%s] get work connection from chan", b.Name)
+			b.reqWorkConn()
+			if b.StartWorkConn {
+				if err := protocol.WriteMsg(workConn, startMsg); err != nil {
+					// the pooled connection may be broken while idle, try the next one
+					log.Warnf("proxy:[%s] start work connection err: %s", b.Name, err)
+					workConn.Close()
+					continue
+				}
+			}
+			conn, err := netio.WrapTunnelConn(workConn, b.UseEncryption, b.UseCompression, b.Token)
+			if err != nil {
+				workConn.Close()
+				return nil, err
+			}
+			for _, limiter := range b.limiters {
+				conn = netio.NewLimitConn(conn, limiter[1], limiter[0])
+			}
+			return b.stats.countConn(conn), nil
+		case <-timeout:
+			b.reqWorkConn()
+			return nil, errors.New("timeout trying to get work connection")
+		}
+	}
+}
+
+// reqWorkConn asks the bridge for a new work connection of this proxy.
+func (b *BaseProxy) reqWorkConn() {
+	b.session.AllocateContext().SetResponseMessage(&protocol.ReqWorkCtl{
+		ProxyName: b.Name,
+	}).Send()
+}
+
+// closeWorkConns closes the pooled work connections, no more can be put after it.
+func (b *BaseProxy) closeWorkConns() {
+	b.closeLock.Lock()
+	defer b.closeLock.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.WorkingChan)
+	for workConn := range b.WorkingChan {
+		workConn.Close()
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"breaker/pkg/metrics"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats counts the user connections and the traffic of a proxy, every work
+// connection taken by GetWorkConn serves one user connection.
+type Stats struct {
+	activeConns int64
+	totalConns  int64
+	// rejectedConns are rejected by the limits of connections
+	rejectedConns int64
+	// deniedConns are denied by the ip lists
+	deniedConns int64
+	// bytesIn is sent by users to the bridge, bytesOut is sent back to users
+	bytesIn  int64
+	bytesOut int64
+	// workConnWait observes the seconds GetWorkConn waits for a work connection
+	workConnWait *metrics.Histogram
+}
+
+func newStats() *Stats {
+	return &Stats{
+		workConnWait: metrics.NewHistogram(metrics.DefBuckets),
+	}
+}
+
+// ActiveConns returns the number of user connections being tunneled.
+func (s *Stats) ActiveConns() int64 {
+	return atomic.LoadInt64(&s.activeConns)
+}
+
+func (s *Stats) TotalConns() int64 {
+	return atomic.LoadInt64(&s.totalConns)
+}
+
+func (s *Stats) RejectedConns() int64 {
+	return atomic.LoadInt64(&s.rejectedConns)
+}
+
+func (s *Stats) reject() {
+	atomic.AddInt64(&s.rejectedConns, 1)
+}
+
+func (s *Stats) DeniedConns() int64 {
+	return atomic.LoadInt64(&s.deniedConns)
+}
+
+func (s *Stats) deny() {
+	atomic.AddInt64(&s.deniedConns, 1)
+}
+
+func (s *Stats) BytesIn() int64 {
+	return atomic.LoadInt64(&s.bytesIn)
+}
+
+func (s *Stats) BytesOut() int64 {
+	return atomic.LoadInt64(&s.bytesOut)
+}
+
+// WorkConnWait returns the histogram of seconds waiting for work connections.
+func (s *Stats) WorkConnWait() *metrics.Histogram {
+	return s.workConnWait
+}
+
+// countConn counts the traffic of a work connection until it's closed.
+func (s *Stats) countConn(conn net.Conn) net.Conn {
+	atomic.AddInt64(&s.activeConns, 1)
+	atomic.AddInt64(&s.totalConns, 1)
+	return &statsConn{Conn: conn, stats: s}
+}
+
+type statsConn struct {
+	net.Conn
+	stats     *Stats
+	closeOnce sync.Once
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.stats.bytesOut, int64(n))
+	return n, err
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.stats.bytesIn, int64(n))
+	return n, err
+}
+
+func (c *statsConn) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.AddInt64(&c.stats.activeConns, -1)
+	})
+	return c.Conn.Close()
+}
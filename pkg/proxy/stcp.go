@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"breaker/pkg/auth"
+	"errors"
+	"sync"
+	"time"
+)
+
+// StcpProxy opens no port on the portal, its work connections are paired with
+// the connections of visitors which know the secret key.
+type StcpProxy struct {
+	*BaseProxy
+	// Sk is the secret key shared with visitors.
+	Sk string
+	// verifier rejects sign keys replayed within the window
+	verifier  *auth.Verifier
+	registry  *StcpRegistry
+	closeOnce sync.Once
+}
+
+func NewStcpProxy(base *BaseProxy, registry *StcpRegistry, sk string, window time.Duration) *StcpProxy {
+	return &StcpProxy{
+		BaseProxy: base,
+		Sk:        sk,
+		verifier:  auth.NewVerifier(sk, window),
+		registry:  registry,
+	}
+}
+
+// Serve registers the proxy so that visitors can find it by name, addr is ignored.
+func (s *StcpProxy) Serve(addr string) error {
+	return s.registry.add(s)
+}
+
+// VerifyVisitor checks the key signed by the visitor with the secret key, every
+// nonce is accepted only once.
+func (s *StcpProxy) VerifyVisitor(timestamp int64, nonce string, signKey string) error {
+	return s.verifier.Verify(timestamp, nonce, signKey)
+}
+
+func (s *StcpProxy) Close() {
+	s.closeOnce.Do(func() {
+		s.registry.del(s)
+		s.closeWorkConns()
+	})
+}
+
+// StcpRegistry finds stcp proxies by name, the names are unique among all bridges.
+type StcpRegistry struct {
+	proxies map[string]*StcpProxy
+	lock    sync.RWMutex
+}
+
+func NewStcpRegistry() *StcpRegistry {
+	return &StcpRegistry{
+		proxies: make(map[string]*StcpProxy),
+	}
+}
+
+func (r *StcpRegistry) Get(name string) (*StcpProxy, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	pxy, ok := r.proxies[name]
+	return pxy, ok
+}
+
+func (r *StcpRegistry) add(pxy *StcpProxy) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.proxies[pxy.Name]; ok {
+		return errors.New("stcp proxy:" + pxy.Name + " already exist")
+	}
+	r.proxies[pxy.Name] = pxy
+	return nil
+}
+
+func (r *StcpRegistry) del(pxy *StcpProxy) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.proxies[pxy.Name] == pxy {
+		delete(r.proxies, pxy.Name)
+	}
+}
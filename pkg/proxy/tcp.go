@@ -1,10 +1,7 @@
 package proxy
 
 import (
-	"breaker/pkg/breaker"
 	"breaker/pkg/netio"
-	"breaker/pkg/protocol"
-	"errors"
 	log "github.com/sirupsen/logrus"
 	"net"
 	"sync"
@@ -12,21 +9,40 @@ import (
 )
 
 type TcpProxy struct {
-	Name string
+	*BaseProxy
 	net.Listener
-	WorkingChan chan net.Conn
-	ctx         breaker.Context
-	closeOnce   sync.Once
+	// groups and groupConf are set if the proxy shares its port with other bridges
+	groups    *TcpGroupManager
+	groupConf GroupConfig
+	group     *TcpGroup
+	closeOnce sync.Once
 }
 
-func NewTcpProxy(name string, ctx breaker.Context) *TcpProxy {
+func NewTcpProxy(base *BaseProxy) *TcpProxy {
 	return &TcpProxy{
-		Name:        name,
-		ctx:         ctx,
-		WorkingChan: make(chan net.Conn, 10),
+		BaseProxy: base,
 	}
 }
+
+// NewGroupTcpProxy creates a member of the load balanced group, the users of the
+// shared port are distributed among the members.
+func NewGroupTcpProxy(base *BaseProxy, groups *TcpGroupManager, conf GroupConfig) *TcpProxy {
+	return &TcpProxy{
+		BaseProxy: base,
+		groups:    groups,
+		groupConf: conf,
+	}
+}
+
 func (t *TcpProxy) Serve(addr string) error {
+	if t.groups != nil {
+		group, err := t.groups.Join(t, addr, t.groupConf)
+		if err != nil {
+			return err
+		}
+		t.group = group
+		return nil
+	}
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
@@ -36,70 +52,67 @@ func (t *TcpProxy) Serve(addr string) error {
 		defer func() {
 			log.Infof("proxy:[%s] exist", t.Name)
 		}()
-		for {
-			userconn, err := listener.Accept()
-			var tempDelay time.Duration // how long to sleep on accept failure
+		acceptLoop(listener, func(userconn net.Conn) {
+			// denied and excess connections are rejected before they wait for work connections
+			if !t.allowedConn(userconn) {
+				userconn.Close()
+				return
+			}
+			release, ok := t.acquireConn(userconn)
+			if !ok {
+				return
+			}
+			defer release()
+			workConn, err := t.GetUserWorkConn(userconn)
 			if err != nil {
-				if err, ok := err.(net.Error); ok && err.Temporary() {
-					if tempDelay == 0 {
-						tempDelay = 5 * time.Millisecond
-					} else {
-						tempDelay *= 2
-					}
-					if max := 1 * time.Second; tempDelay > max {
-						tempDelay = max
-					}
-					log.Infof("met temporary error: %s, sleep for %s ...", err, tempDelay)
-					time.Sleep(tempDelay)
-					continue
-				}
-				log.Errorf("met pxy accept error: %s", err)
+				log.Errorf("can not get work conn with err:[%+v]", err)
+				userconn.Close()
 				return
 			}
-			go func() {
-				clientWorkConn, err := t.GetWorkConn()
-				if err != nil {
-					log.Errorf("can not get work conn with err:[%+v]", err)
-					userconn.Close()
-					return
-				}
-				netio.StartTunnel(clientWorkConn, userconn)
-			}()
-
-		}
+			netio.StartTunnel(workConn, userconn)
+		})
 	}()
 
 	return nil
 }
-func (t *TcpProxy) GetWorkConn() (net.Conn, error) {
-	// get a work connection from the chan
-	for {
-		select {
-		case workConn := <-t.WorkingChan:
-			log.Info("get work connection from chan")
-			t.ctx.SetResponseMessage(&protocol.ReqWorkCtl{
-				ProxyName: t.Name,
-			})
-			t.ctx.Send()
-			return workConn, nil
-		case <-time.After(time.Duration(5) * time.Second):
-			t.ctx.SetResponseMessage(&protocol.ReqWorkCtl{
-				ProxyName: t.Name,
-			})
-			t.ctx.Send()
-			return nil, errors.New("timeout trying to get work connection")
-		}
-	}
-}
 
 func (t *TcpProxy) Close() {
 	t.closeOnce.Do(func() {
-		t.Listener.Close()
-		close(t.WorkingChan)
-		for workConn := range t.WorkingChan {
-			workConn.Close()
+		if t.group != nil {
+			t.groups.Leave(t.group, t)
 		}
-
+		if t.Listener != nil {
+			t.Listener.Close()
+		}
+		t.closeWorkConns()
 	})
 
 }
+
+// acceptLoop serves every user connection of listener in a new goroutine, it returns
+// when the listener is closed.
+func acceptLoop(listener net.Listener, handle func(userconn net.Conn)) {
+	var tempDelay time.Duration // how long to sleep on accept failure
+	for {
+		userconn, err := listener.Accept()
+		if err != nil {
+			if err, ok := err.(net.Error); ok && err.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				log.Infof("met temporary error: %s, sleep for %s ...", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			log.Errorf("met pxy accept error: %s", err)
+			return
+		}
+		tempDelay = 0
+		go handle(userconn)
+	}
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"breaker/pkg/protocol"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// udpIdleTimeout releases the local socket of a user without traffic
+	udpIdleTimeout = 60 * time.Second
+	// maxUDPPacketSize is the buffer size of reading datagrams
+	maxUDPPacketSize = 64 * 1024
+	// udpSendQueueSize is the number of datagrams waiting for a work connection, more are dropped
+	udpSendQueueSize = 1024
+)
+
+// UdpProxy listens on an udp port, datagrams of all users are framed over one work connection.
+type UdpProxy struct {
+	*BaseProxy
+	conn      *net.UDPConn
+	sendCh    chan *protocol.UDPPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewUdpProxy(base *BaseProxy) *UdpProxy {
+	return &UdpProxy{
+		BaseProxy: base,
+		sendCh:    make(chan *protocol.UDPPacket, udpSendQueueSize),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (u *UdpProxy) Serve(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	u.conn = conn
+	go u.readUsers()
+	go u.serveWorkConns()
+	return nil
+}
+
+// readUsers queues the datagrams of users, they are dropped if the queue is full.
+func (u *UdpProxy) readUsers() {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, from, err := u.conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Infof("proxy:[%s] exist: %s", u.Name, err)
+			return
+		}
+		packet := &protocol.UDPPacket{
+			RemoteAddr: from.String(),
+			Content:    append([]byte(nil), buf[:n]...),
+		}
+		select {
+		case u.sendCh <- packet:
+		default:
+			log.Warnf("proxy:[%s] send queue is full, drop packet from:[%s]", u.Name, from)
+		}
+	}
+}
+
+// serveWorkConns keeps one work connection relaying, a new one is taken when it breaks.
+func (u *UdpProxy) serveWorkConns() {
+	for {
+		select {
+		case <-u.closed:
+			return
+		default:
+		}
+		workConn, err := u.GetWorkConn()
+		if err != nil {
+			if err == ErrProxyClosed {
+				return
+			}
+			log.Errorf("proxy:[%s] can not get work conn with err:[%+v]", u.Name, err)
+			continue
+		}
+		u.relay(workConn)
+	}
+}
+
+func (u *UdpProxy) relay(workConn net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			packet, err := protocol.ReadUDPPacket(workConn)
+			if err != nil {
+				return
+			}
+			raddr, err := net.ResolveUDPAddr("udp", packet.RemoteAddr)
+			if err != nil {
+				log.Errorf("proxy:[%s] invalid remote address:[%s]", u.Name, packet.RemoteAddr)
+				continue
+			}
+			if _, err := u.conn.WriteToUDP(packet.Content, raddr); err != nil {
+				log.Errorf("proxy:[%s] write to:[%s] err: %s", u.Name, raddr, err)
+			}
+		}
+	}()
+	defer func() {
+		workConn.Close()
+		<-done
+	}()
+	for {
+		select {
+		case packet := <-u.sendCh:
+			if err := protocol.WriteUDPPacket(workConn, packet); err != nil {
+				log.Errorf("proxy:[%s] write work conn err: %s", u.Name, err)
+				return
+			}
+		case <-done:
+			return
+		case <-u.closed:
+			return
+		}
+	}
+}
+
+func (u *UdpProxy) Close() {
+	u.closeOnce.Do(func() {
+		close(u.closed)
+		if u.conn != nil {
+			u.conn.Close()
+		}
+		u.closeWorkConns()
+	})
+}
+
+// RelayUDP is used by the bridge, it relays the datagrams of the work connection to
+// the local udp service. Every user gets its own local socket so that replies can be
+// routed back, the socket is released after udpIdleTimeout without traffic.
+func RelayUDP(workConn net.Conn, localAddr string) {
+	laddr, err := net.ResolveUDPAddr("udp", localAddr)
+	if err != nil {
+		log.Errorf("invalid local udp address:[%s]", localAddr)
+		workConn.Close()
+		return
+	}
+	var (
+		writeLock sync.Mutex
+		peers     = make(map[string]*net.UDPConn)
+		peerLock  sync.Mutex
+	)
+	readReplies := func(remoteAddr string, local *net.UDPConn) {
+		defer func() {
+			peerLock.Lock()
+			if peers[remoteAddr] == local {
+				delete(peers, remoteAddr)
+			}
+			peerLock.Unlock()
+			local.Close()
+		}()
+		buf := make([]byte, maxUDPPacketSize)
+		for {
+			n, err := local.Read(buf)
+			if err != nil {
+				return
+			}
+			local.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+			writeLock.Lock()
+			err = protocol.WriteUDPPacket(workConn, &protocol.UDPPacket{RemoteAddr: remoteAddr, Content: buf[:n]})
+			writeLock.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+	defer func() {
+		workConn.Close()
+		peerLock.Lock()
+		for _, local := range peers {
+			local.Close()
+		}
+		peerLock.Unlock()
+	}()
+	for {
+		packet, err := protocol.ReadUDPPacket(workConn)
+		if err != nil {
+			return
+		}
+		peerLock.Lock()
+		local, ok := peers[packet.RemoteAddr]
+		if !ok {
+			local, err = net.DialUDP("udp", nil, laddr)
+			if err != nil {
+				peerLock.Unlock()
+				log.Errorf("dial local udp:[%s] err: %s", localAddr, err)
+				continue
+			}
+			peers[packet.RemoteAddr] = local
+			go readReplies(packet.RemoteAddr, local)
+		}
+		peerLock.Unlock()
+		local.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		if _, err := local.Write(packet.Content); err != nil {
+			log.Errorf("write local udp:[%s] err: %s", localAddr, err)
+		}
+	}
+}
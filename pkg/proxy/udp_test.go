@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"breaker/pkg/protocol"
+)
+
+// udpEcho replies every datagram with its content, and records the source addresses.
+func udpEcho(t *testing.T) (*net.UDPConn, func() int) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lock sync.Mutex
+	sources := make(map[string]bool)
+	go func() {
+		buf := make([]byte, maxUDPPacketSize)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			sources[from.String()] = true
+			lock.Unlock()
+			conn.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return conn, func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(sources)
+	}
+}
+
+func TestRelayUDP(t *testing.T) {
+	echo, sources := udpEcho(t)
+	defer echo.Close()
+	portal, bridge := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		RelayUDP(bridge, echo.LocalAddr().String())
+		close(done)
+	}()
+
+	users := []string{"1.1.1.1:1000", "2.2.2.2:2000"}
+	replies := make(chan *protocol.UDPPacket, 10)
+	go func() {
+		for {
+			packet, err := protocol.ReadUDPPacket(portal)
+			if err != nil {
+				close(replies)
+				return
+			}
+			replies <- packet
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		for _, user := range users {
+			if err := protocol.WriteUDPPacket(portal, &protocol.UDPPacket{
+				RemoteAddr: user,
+				Content:    []byte("from " + user),
+			}); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case reply := <-replies:
+				// the reply goes back to the user who sent the datagram
+				if reply.RemoteAddr != user || string(reply.Content) != "from "+user {
+					t.Fatalf("got reply %s:%q for %s", reply.RemoteAddr, reply.Content, user)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("no reply")
+			}
+		}
+	}
+	// every user has its own local socket, and keeps it
+	if n := sources(); n != len(users) {
+		t.Fatalf("the local service sees %d sources, want %d", n, len(users))
+	}
+
+	portal.Close()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("relay doesn't return after the work connection is closed")
+	}
+}
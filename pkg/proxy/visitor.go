@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"breaker/pkg/netio"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"sync"
+)
+
+// Visitor runs on the bridge, it listens locally and pairs every connection with
+// a work connection of the stcp proxy through the portal.
+type Visitor struct {
+	Name string
+	// dial returns a connection to the portal which is already paired
+	dial      func() (net.Conn, error)
+	listener  net.Listener
+	closeOnce sync.Once
+}
+
+func NewVisitor(name string, dial func() (net.Conn, error)) *Visitor {
+	return &Visitor{
+		Name: name,
+		dial: dial,
+	}
+}
+
+func (v *Visitor) Serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	v.listener = listener
+	log.Infof("visitor:[%s] listen at:[%s]", v.Name, addr)
+	go acceptLoop(listener, v.handleConn)
+	return nil
+}
+
+func (v *Visitor) handleConn(userConn net.Conn) {
+	visitorConn, err := v.dial()
+	if err != nil {
+		log.Errorf("visitor:[%s] connect to proxy err: %s", v.Name, err)
+		userConn.Close()
+		return
+	}
+	netio.StartTunnel(visitorConn, userConn)
+}
+
+func (v *Visitor) Close() {
+	v.closeOnce.Do(func() {
+		if v.listener != nil {
+			v.listener.Close()
+		}
+	})
+}
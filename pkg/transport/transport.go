@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"breaker/pkg/kcp"
+	"fmt"
+	"net"
+)
+
+// names of the protocols used between bridge and portal
+const (
+	ProtocolTCP = "tcp"
+	ProtocolKCP = "kcp"
+)
+
+// Transport carries the master connection and work connections between bridge and portal.
+type Transport interface {
+	Dial(addr string) (net.Conn, error)
+	Listen(addr string) (net.Listener, error)
+}
+
+// New returns the transport of protocol, kcpConfig is only used by kcp.
+func New(protocol string, kcpConfig *kcp.Config) (Transport, error) {
+	switch protocol {
+	case "", ProtocolTCP:
+		return NewTCPTransport(), nil
+	case ProtocolKCP:
+		return NewKCPTransport(kcpConfig), nil
+	default:
+		return nil, fmt.Errorf("unsupported transport protocol: %s", protocol)
+	}
+}
+
+type TCPTransport struct{}
+
+func NewTCPTransport() *TCPTransport {
+	return &TCPTransport{}
+}
+
+func (t *TCPTransport) Dial(addr string) (net.Conn, error) {
+	return net.Dial("tcp", addr)
+}
+
+func (t *TCPTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+// KCPTransport is a reliable transport over udp, it behaves better than tcp on lossy links.
+type KCPTransport struct {
+	Config *kcp.Config
+}
+
+func NewKCPTransport(config *kcp.Config) *KCPTransport {
+	if config == nil {
+		config = kcp.DefaultConfig()
+	}
+	return &KCPTransport{Config: config}
+}
+
+func (t *KCPTransport) Dial(addr string) (net.Conn, error) {
+	conn, err := kcp.Dial(addr, t.Config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (t *KCPTransport) Listen(addr string) (net.Listener, error) {
+	listener, err := kcp.Listen(addr, t.Config)
+	if err != nil {
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,99 @@
+package vhost
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+const (
+	notFoundPage = `<!DOCTYPE html>
+<html>
+<head><title>Not Found</title></head>
+<body>
+<h1>404 Not Found</h1>
+<p>The page you requested was not found, please check the domain and the path.</p>
+</body>
+</html>
+`
+	responseHeaderTimeout = 60 * time.Second
+)
+
+// DialFunc returns a connection to the local service of the bridge.
+type DialFunc func() (net.Conn, error)
+
+type dialKey struct{}
+
+// HTTPMuxer is an http server routing requests to proxies by the Host header and the path.
+type HTTPMuxer struct {
+	routers *Routers
+	proxy   *httputil.ReverseProxy
+}
+
+func NewHTTPMuxer() *HTTPMuxer {
+	m := &HTTPMuxer{
+		routers: NewRouters(),
+	}
+	m.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = req.Host
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return ctx.Value(dialKey{}).(DialFunc)()
+			},
+			// routes of the same host may belong to different bridges,
+			// so a connection is never reused by another request
+			DisableKeepAlives:     true,
+			ResponseHeaderTimeout: responseHeaderTimeout,
+		},
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			log.Errorf("vhost http:[%s%s] error: %s", req.Host, req.URL.Path, err)
+			rw.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return m
+}
+
+// Register routes requests of the domain and location to dial, an empty
+// location matches every path.
+func (m *HTTPMuxer) Register(domain, location string, dial DialFunc) error {
+	if err := m.routers.Add(domain, location, dial); err != nil {
+		return fmt.Errorf("domain:[%s] location:[%s] %s", domain, location, err)
+	}
+	return nil
+}
+
+func (m *HTTPMuxer) Unregister(domain, location string) {
+	m.routers.Del(domain, location)
+}
+
+func (m *HTTPMuxer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = req.Host
+	}
+	route, ok := m.routers.Get(host, req.URL.Path)
+	if !ok {
+		log.Debugf("vhost http:[%s%s] not found", req.Host, req.URL.Path)
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.WriteHeader(http.StatusNotFound)
+		rw.Write([]byte(notFoundPage))
+		return
+	}
+	ctx := context.WithValue(req.Context(), dialKey{}, route.Payload.(DialFunc))
+	m.proxy.ServeHTTP(rw, req.WithContext(ctx))
+}
+
+// Serve accepts http requests on l, it blocks until l is closed.
+func (m *HTTPMuxer) Serve(l net.Listener) error {
+	server := &http.Server{
+		Handler: m,
+	}
+	return server.Serve(l)
+}
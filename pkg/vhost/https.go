@@ -0,0 +1,132 @@
+package vhost
+
+import (
+	"breaker/pkg/netio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"time"
+)
+
+const sniTimeout = 10 * time.Second
+
+var errSniffDone = errors.New("client hello is sniffed")
+
+// HTTPSMuxer routes tls connections to proxies by the server name of the
+// ClientHello, tls is not terminated so that services keep their own certificates.
+type HTTPSMuxer struct {
+	routers *Routers
+}
+
+func NewHTTPSMuxer() *HTTPSMuxer {
+	return &HTTPSMuxer{
+		routers: NewRouters(),
+	}
+}
+
+func (m *HTTPSMuxer) Register(domain string, dial DialFunc) error {
+	if err := m.routers.Add(domain, "/", dial); err != nil {
+		return fmt.Errorf("domain:[%s] %s", domain, err)
+	}
+	return nil
+}
+
+func (m *HTTPSMuxer) Unregister(domain string) {
+	m.routers.Del(domain, "/")
+}
+
+// Serve accepts tls connections on l, it blocks until l is closed.
+func (m *HTTPSMuxer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go m.handleConn(conn)
+	}
+}
+
+func (m *HTTPSMuxer) handleConn(conn net.Conn) {
+	if err := conn.SetReadDeadline(time.Now().Add(sniTimeout)); err != nil {
+		conn.Close()
+		return
+	}
+	serverName, conn, err := sniffServerName(conn)
+	if err != nil {
+		log.Debugf("vhost https:[%s] sniff server name err: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	route, ok := m.routers.Get(serverName, "/")
+	if !ok {
+		log.Debugf("vhost https:[%s] not found", serverName)
+		conn.Close()
+		return
+	}
+	workConn, err := route.Payload.(DialFunc)()
+	if err != nil {
+		log.Errorf("vhost https:[%s] error: %s", serverName, err)
+		conn.Close()
+		return
+	}
+	netio.StartTunnel(workConn, conn)
+}
+
+// sniffServerName reads the ClientHello of conn and returns its server name,
+// the returned conn still reads the ClientHello.
+func sniffServerName(conn net.Conn) (string, net.Conn, error) {
+	buf := &bytes.Buffer{}
+	var serverName string
+	err := tls.Server(&readOnlyConn{Conn: conn, reader: io.TeeReader(conn, buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errSniffDone
+		},
+	}).Handshake()
+	replay := &replayConn{Conn: conn, reader: io.MultiReader(buf, conn)}
+	if serverName == "" {
+		if err == nil || err == errSniffDone {
+			err = errors.New("no server name")
+		}
+		return "", replay, err
+	}
+	return serverName, replay, nil
+}
+
+// readOnlyConn feeds the tls handshake, nothing is written back to the user.
+type readOnlyConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *readOnlyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *readOnlyConn) Write(b []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func (c *readOnlyConn) Close() error { return nil }
+
+func (c *readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type replayConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
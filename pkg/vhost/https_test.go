@@ -0,0 +1,105 @@
+package vhost
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate of the names.
+func testCertificate(t *testing.T, names ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSniffServerName(t *testing.T) {
+	cert := testCertificate(t, "app.example.com")
+	user, portal := net.Pipe()
+	defer user.Close()
+	defer portal.Close()
+	handshake := make(chan error, 1)
+	go func() {
+		conn := tls.Client(user, &tls.Config{ServerName: "app.example.com", InsecureSkipVerify: true})
+		if err := conn.Handshake(); err != nil {
+			handshake <- err
+			return
+		}
+		_, err := conn.Write([]byte("ping"))
+		handshake <- err
+	}()
+
+	serverName, conn, err := sniffServerName(portal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverName != "app.example.com" {
+		t.Fatalf("got server name %q", serverName)
+	}
+	// the service completes the handshake with the replayed ClientHello
+	service := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(service, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("got %q", buf)
+	}
+	if err := <-handshake; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSniffNoServerName(t *testing.T) {
+	user, portal := net.Pipe()
+	defer portal.Close()
+	go func() {
+		// without ServerName the ClientHello has no SNI
+		tls.Client(user, &tls.Config{InsecureSkipVerify: true}).Handshake()
+	}()
+	defer user.Close()
+	if _, _, err := sniffServerName(portal); err == nil {
+		t.Fatal("no error without server name")
+	}
+}
+
+func TestSniffNotTLS(t *testing.T) {
+	user, portal := net.Pipe()
+	defer user.Close()
+	defer portal.Close()
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	go user.Write([]byte(request))
+	_, conn, err := sniffServerName(portal)
+	if err == nil {
+		t.Fatal("no error of plain http")
+	}
+	// the peeked bytes are still read from the conn
+	buf := make([]byte, len(request))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != request {
+		t.Fatalf("got %q", buf)
+	}
+}
@@ -0,0 +1,103 @@
+package vhost
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrRouteExist = errors.New("route already exist")
+
+// Route binds a location of the domain to a proxy.
+type Route struct {
+	Domain   string
+	Location string
+	Payload  interface{}
+}
+
+// Routers finds the route of a host and path, locations of the same domain
+// are matched by prefix and the longest one wins.
+type Routers struct {
+	// domain => routes sorted by the length of location, longest first
+	routes map[string][]*Route
+	lock   sync.RWMutex
+}
+
+func NewRouters() *Routers {
+	return &Routers{
+		routes: make(map[string][]*Route),
+	}
+}
+
+func (r *Routers) Add(domain, location string, payload interface{}) error {
+	domain, location = normalizeDomain(domain), normalizeLocation(location)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	routes := r.routes[domain]
+	for _, route := range routes {
+		if route.Location == location {
+			return ErrRouteExist
+		}
+	}
+	routes = append(routes, &Route{Domain: domain, Location: location, Payload: payload})
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Location) > len(routes[j].Location)
+	})
+	r.routes[domain] = routes
+	return nil
+}
+
+func (r *Routers) Del(domain, location string) {
+	domain, location = normalizeDomain(domain), normalizeLocation(location)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	routes := r.routes[domain]
+	for i, route := range routes {
+		if route.Location == location {
+			routes = append(routes[:i:i], routes[i+1:]...)
+			break
+		}
+	}
+	if len(routes) == 0 {
+		delete(r.routes, domain)
+		return
+	}
+	r.routes[domain] = routes
+}
+
+// Get returns the route of host and path, the wildcard domain "*.example.com"
+// is tried if there's no route of "sub.example.com".
+func (r *Routers) Get(host, path string) (*Route, bool) {
+	host = normalizeDomain(host)
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if route, ok := r.match(host, path); ok {
+		return route, true
+	}
+	if i := strings.Index(host, "."); i > 0 {
+		return r.match("*"+host[i:], path)
+	}
+	return nil, false
+}
+
+func (r *Routers) match(domain, path string) (*Route, bool) {
+	for _, route := range r.routes[domain] {
+		if strings.HasPrefix(path, route.Location) {
+			return route, true
+		}
+	}
+	return nil, false
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSpace(domain))
+}
+
+func normalizeLocation(location string) string {
+	location = strings.TrimSpace(location)
+	if !strings.HasPrefix(location, "/") {
+		location = "/" + location
+	}
+	return location
+}
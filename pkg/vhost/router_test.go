@@ -0,0 +1,77 @@
+package vhost
+
+import "testing"
+
+func TestRoutersGet(t *testing.T) {
+	r := NewRouters()
+	routes := []struct {
+		domain, location, payload string
+	}{
+		{"example.com", "/", "root"},
+		{"example.com", "/api", "api"},
+		{"example.com", "/api/v2", "api-v2"},
+		{"Static.Example.com ", "", "static"},
+		{"*.example.com", "/", "wildcard"},
+		{"*.example.com", "/admin", "wildcard-admin"},
+		{"only.example.org", "/app", "app"},
+	}
+	for _, route := range routes {
+		if err := r.Add(route.domain, route.location, route.payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name, host, path string
+		want             string
+	}{
+		{"root location", "example.com", "/index.html", "root"},
+		{"prefix location", "example.com", "/api/users", "api"},
+		{"longest prefix", "example.com", "/api/v2/users", "api-v2"},
+		{"case of host", "EXAMPLE.com", "/api", "api"},
+		{"normalized route", "static.example.com", "/css/a.css", "static"},
+		{"wildcard fallback", "www.example.com", "/", "wildcard"},
+		{"wildcard longest prefix", "www.example.com", "/admin/users", "wildcard-admin"},
+		{"exact domain before wildcard", "static.example.com", "/admin", "static"},
+		{"wildcard of one level only", "a.b.example.com", "/", ""},
+		{"no location matched", "only.example.org", "/other", ""},
+		{"no domain", "example.net", "/", ""},
+		{"no wildcard of top level", "com", "/", ""},
+	}
+	for _, tt := range tests {
+		route, ok := r.Get(tt.host, tt.path)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%s: got route %v", tt.name, route.Payload)
+			}
+			continue
+		}
+		if !ok || route.Payload != tt.want {
+			t.Errorf("%s: Get(%s, %s) = %v, %v, want %s", tt.name, tt.host, tt.path, route, ok, tt.want)
+		}
+	}
+}
+
+func TestRoutersAddDel(t *testing.T) {
+	r := NewRouters()
+	if err := r.Add("example.com", "/api", 1); err != nil {
+		t.Fatal(err)
+	}
+	// locations are normalized before they're compared
+	if err := r.Add("EXAMPLE.COM", "api", 2); err != ErrRouteExist {
+		t.Fatalf("got %v, want ErrRouteExist", err)
+	}
+	if err := r.Add("example.com", "/", 3); err != nil {
+		t.Fatal(err)
+	}
+	r.Del("example.com", "api")
+	if route, ok := r.Get("example.com", "/api"); !ok || route.Payload != 3 {
+		t.Fatal("deleted location is still matched")
+	}
+	r.Del("example.com", "/")
+	if _, ok := r.Get("example.com", "/"); ok {
+		t.Fatal("route of the deleted domain is matched")
+	}
+	if len(r.routes) != 0 {
+		t.Fatal("empty domain is kept")
+	}
+}
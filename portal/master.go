@@ -1,6 +1,7 @@
 package portal
 
 import (
+	"breaker/pkg/breaker"
 	"breaker/pkg/protocol"
 	"fmt"
 	"net"
@@ -62,6 +63,9 @@ func (m *MasterManager) CheckConn() {
 		case <-heartbeat.C:
 			m.masterByTrackID.Range(func(key, value interface{}) bool {
 				master := value.(*Master)
+				if !master.Capability.HasFeature(protocol.FeatureHeartbeat) {
+					return true
+				}
 				if time.Since(master.LastPingTime) > time.Duration(m.HeartbeatTimeout)*time.Second {
 					master.Close()
 				}
@@ -80,6 +84,10 @@ type Master struct {
 	writeChan    chan protocol.Command
 	once         sync.Once
 	LastPingTime time.Time
+	// Capability is negotiated with the bridge during login.
+	Capability protocol.Capability
+	// Session is the master session of the bridge.
+	Session breaker.Session
 }
 
 func NewMaster(TrackID string, Conn net.Conn) *Master {